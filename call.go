@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"pulse/utils"
+
+	"github.com/spf13/cobra"
+)
+
+var callTimeout time.Duration
+
+var callCmd = &cobra.Command{
+	Use:   "call <id> <payload>",
+	Short: "Send a request to a channel and wait for the matching response",
+	Long: `Send a request to a channel and wait for the matching response.
+
+The request carries a correlation ID and a reply tag. Only the response
+to this request is printed, as answered by "pulse serve" on the same ID.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if callTimeout <= 0 {
+			return fmt.Errorf("--timeout must be positive")
+		}
+		return utils.Call(args[0], args[1], callTimeout, verbose)
+	},
+}
+
+func init() {
+	callCmd.Flags().DurationVar(&callTimeout, "timeout", 10*time.Second, "How long to wait for the response")
+	callCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Verbose output with relay status")
+	rootCmd.AddCommand(callCmd)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"pulse/pulse"
+	"pulse/utils"
+)
+
+// newClient creates a library client with the configuration loaded from pulse.conf
+// With -v each relay's status is printed as it finishes
+func newClient() (*pulse.Client, error) {
+	opts := configOptions(utils.CurrentSettings())
+	if verbose {
+		opts = append(opts, pulse.WithLogger(log.New(os.Stdout, "", 0)))
+	}
+	return pulse.New(opts...)
+}
+
+// configOptions translates the command's configuration into client options
+func configOptions(s *utils.Settings) []pulse.Option {
+	opts := []pulse.Option{
+		pulse.WithRelays(s.Relays...),
+		pulse.WithSecret(s.UserSecret),
+		pulse.WithHistoryLimit(s.HistoryLimit),
+		pulse.WithEventKind(s.EventKind),
+		pulse.WithEphemeralKind(s.EphemeralKind),
+		pulse.WithLatestKind(s.LatestKind),
+		pulse.WithReadLegacy(s.ReadLegacy),
+		pulse.WithCompression(s.Compression),
+		pulse.WithPow(s.Pow),
+		pulse.WithMaxPow(s.MaxPow),
+		pulse.WithMaxMessageSize(s.MaxMessageSize),
+	}
+	if len(s.ReadRelays) > 0 {
+		opts = append(opts, pulse.WithReadRelays(s.ReadRelays...))
+	}
+	if len(s.WriteRelays) > 0 {
+		opts = append(opts, pulse.WithWriteRelays(s.WriteRelays...))
+	}
+	if s.IdentityKey != "" {
+		opts = append(opts, pulse.WithIdentityKey(s.IdentityKey))
+	}
+	if s.AuthKey != "" {
+		opts = append(opts, pulse.WithAuthKey(s.AuthKey))
+	}
+	if s.Proxy != "" {
+		opts = append(opts, pulse.WithProxy(s.Proxy))
+	}
+	if s.DataDir != "" {
+		opts = append(opts, pulse.WithDataDir(s.DataDir))
+	}
+	for id, cc := range s.Channels {
+		opts = append(opts, pulse.WithChannel(id, pulse.ChannelConfig{
+			Relays:      cc.Relays,
+			ReadRelays:  cc.ReadRelays,
+			WriteRelays: cc.WriteRelays,
+			Kind:        cc.Kind,
+			ReadLegacy:  cc.ReadLegacy,
+			Ephemeral:   cc.Ephemeral,
+			Latest:      cc.Latest,
+		}))
+	}
+	for url, rc := range s.RelayConfigs {
+		opts = append(opts, pulse.WithRelay(url, pulse.RelayConfig{AuthKey: rc.AuthKey, Proxy: rc.Proxy, Pow: rc.Pow}))
+	}
+	return opts
+}
+
+// sendMessage sends a message, through the daemon when one is running
+func sendMessage(id string, message string, opts utils.SendOptions) error {
+	startTime := time.Now()
+
+	if verbose {
+		fmt.Println("Sending message...")
+	}
+
+	eventID, err := sendThroughDaemon(id, message, opts)
+	if errors.Is(err, utils.ErrNoDaemon) {
+		eventID, err = sendWithClient(id, message, opts)
+	}
+
+	if errors.Is(err, pulse.ErrNoRelay) {
+		fmt.Print("Failure - publish error")
+		return err
+	}
+	if err != nil {
+		fmt.Print("Failure - encryption error")
+		return err
+	}
+
+	if verbose {
+		fmt.Printf("Event ID: %s\n", eventID)
+	}
+	fmt.Print("Success")
+
+	if verbose {
+		fmt.Printf("\nTotal operation time: %dms\n", time.Since(startTime).Milliseconds())
+	}
+	return nil
+}
+
+func sendThroughDaemon(id string, message string, opts utils.SendOptions) (string, error) {
+	if !utils.UseDaemon {
+		return "", utils.ErrNoDaemon
+	}
+	eventID, err := utils.SendThroughDaemon(id, message, opts)
+	if err == nil && verbose {
+		fmt.Println("Sent through the daemon")
+	}
+	return eventID, err
+}
+
+func sendWithClient(id string, message string, opts utils.SendOptions) (string, error) {
+	client, err := newClient()
+	if err != nil {
+		return "", err
+	}
+	msg, err := client.Send(context.Background(), id, message, pulse.SendOptions{
+		Ephemeral: opts.Ephemeral,
+		Latest:    opts.Latest,
+		Once:      opts.Once,
+		TTL:       opts.TTL,
+	})
+	if err != nil {
+		return "", err
+	}
+	return msg.ID, nil
+}
+
+// retrieveMessage prints the most recent message of a channel, through the daemon when one
+// is running; one-time messages are burned once shown
+func retrieveMessage(id string, opts utils.RetrieveOptions) error {
+	startTime := time.Now()
+
+	if verbose {
+		fmt.Println("Retrieving message...")
+	}
+
+	body, burnErr, err := readThroughDaemon(id, opts)
+	if errors.Is(err, utils.ErrNoDaemon) {
+		body, burnErr, err = readWithClient(id, opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(strings.TrimRight(body, "\n"))
+	if burnErr != nil {
+		warnNotBurned(burnErr)
+	}
+
+	if verbose {
+		fmt.Printf("\nTotal operation time: %dms\n", time.Since(startTime).Milliseconds())
+	}
+	return nil
+}
+
+func readThroughDaemon(id string, opts utils.RetrieveOptions) (body string, burnErr error, err error) {
+	if !utils.UseDaemon {
+		return "", nil, utils.ErrNoDaemon
+	}
+	msg, burnErr, err := utils.ReadThroughDaemon(id, opts)
+	if err != nil {
+		return "", nil, err
+	}
+	if verbose {
+		fmt.Println("Read through the daemon")
+	}
+	return msg.Body, burnErr, nil
+}
+
+func readWithClient(id string, opts utils.RetrieveOptions) (body string, burnErr error, err error) {
+	client, err := newClient()
+	if err != nil {
+		return "", nil, err
+	}
+	msg, err := client.Latest(context.Background(), id, pulse.ReadOptions{Latest: opts.Latest})
+	var notBurned *pulse.BurnError
+	if errors.As(err, &notBurned) {
+		return msg.Body, notBurned.Err, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return msg.Body, nil, nil
+}
+
+// warnNotBurned reports a burn failure without hiding the message that was already shown
+func warnNotBurned(err error) {
+	fmt.Fprintf(os.Stderr, "\nwarning: message shown but could not be marked as read: %v\n", err)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"pulse/utils"
+
+	"github.com/spf13/cobra"
+)
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Keep relay connections open for fast send, get and listen",
+	Long: `Run in the foreground, keeping a connection to every relay, the history
+of channels read so far and an outbox of publishes to retry.
+
+While it runs, send, get and listen talk to it over a Unix socket in
+pulse-data instead of connecting to relays themselves. The socket
+speaks newline-delimited JSON-RPC 2.0 with the methods send, get,
+subscribe and status. Set use-daemon = false in pulse.conf to bypass it.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return utils.RunDaemon(verbose)
+	},
+}
+
+var daemonStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the running daemon's connections, channels and outbox",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return utils.PrintDaemonStatus()
+	},
+}
+
+func init() {
+	daemonCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Log each request and retried publish")
+	daemonCmd.AddCommand(daemonStatusCmd)
+	rootCmd.AddCommand(daemonCmd)
+}
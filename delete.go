@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"pulse/utils"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/spf13/cobra"
+)
+
+var deleteChannel string
+var deleteMine bool
+var deleteBefore string
+
+var deleteCmd = &cobra.Command{
+	Use:   "delete [event-id...]",
+	Short: "Retract your messages with NIP-09 deletion requests",
+	Long: `Retract your messages with NIP-09 deletion requests.
+
+Delete specific events by ID, or every message you sent on a channel:
+
+  pulse delete <event-id>
+  pulse delete --channel <id> --mine [--before <time>]
+
+Deleting requires identity-key in pulse.conf, since relays only honor
+deletions signed by the original author.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) > 0 {
+			if deleteMine || deleteBefore != "" {
+				return fmt.Errorf("--mine and --before cannot be combined with event IDs")
+			}
+			return utils.DeleteEvents(args, deleteChannel, verbose)
+		}
+
+		if deleteChannel == "" {
+			return fmt.Errorf("an event ID or --channel is required")
+		}
+		if !deleteMine {
+			return fmt.Errorf("only your own messages can be deleted, pass --mine to confirm")
+		}
+
+		var before nostr.Timestamp
+		if deleteBefore != "" {
+			t, err := parseBefore(deleteBefore)
+			if err != nil {
+				return err
+			}
+			before = nostr.Timestamp(t.Unix())
+		}
+		return utils.DeleteChannelMessages(deleteChannel, before, verbose)
+	},
+}
+
+// parseBefore accepts a timestamp (RFC 3339, date, or unix seconds) or a duration meaning "ago"
+func parseBefore(value string) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if unix, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(unix, 0), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid --before %q, use a date, RFC 3339 time, unix timestamp or duration like 24h", value)
+}
+
+func init() {
+	deleteCmd.Flags().StringVar(&deleteChannel, "channel", "", "Channel ID whose relays and messages to use")
+	deleteCmd.Flags().BoolVar(&deleteMine, "mine", false, "Delete all of your messages on --channel")
+	deleteCmd.Flags().StringVar(&deleteBefore, "before", "", "Only delete messages sent before this time (or this long ago)")
+	deleteCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Verbose output with relay status")
+	rootCmd.AddCommand(deleteCmd)
+}
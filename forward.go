@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"pulse/utils"
+
+	"github.com/spf13/cobra"
+)
+
+var forwardWebhook string
+var forwardSecret string
+var forwardTemplate string
+var forwardContentType string
+var forwardRetries int
+var forwardBackoff time.Duration
+var forwardTimeout time.Duration
+var forwardDeadLetter string
+var forwardCursor string
+
+var forwardCmd = &cobra.Command{
+	Use:   "forward <id>... --webhook <url>",
+	Short: "POST new messages on one or more IDs to a webhook",
+	Long: `POST each new message on one or more IDs to a webhook until interrupted.
+
+The request body is the message as JSON, the same object listen --json
+prints, or the output of --template. Templates use Go text/template
+syntax with the message fields and a json function, for example:
+  --template '{"text": {{json .Body}}}'
+A template starting with @ is read from that file.
+
+Requests carry X-Pulse-Channel and X-Pulse-Event-Id headers. With
+--secret (or webhook-secret in pulse.conf) they are signed:
+X-Pulse-Signature is "sha256=" and the hex HMAC-SHA256 of the body.
+
+Messages are delivered one at a time, in order. Network errors, 5xx,
+408 and 429 responses are retried with exponential backoff; other 4xx
+responses are not. A message that can't be delivered is appended to
+the --dead-letter file as a JSON line, and forwarding moves on.
+With --cursor, a restart resumes after the last message handled.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if forwardWebhook == "" {
+			return fmt.Errorf("--webhook is required")
+		}
+		if forwardRetries < 0 {
+			return fmt.Errorf("--retries cannot be negative")
+		}
+
+		opts := utils.ForwardOptions{
+			Webhook:     forwardWebhook,
+			Secret:      forwardSecret,
+			ContentType: forwardContentType,
+			Retries:     forwardRetries,
+			Backoff:     forwardBackoff,
+			Timeout:     forwardTimeout,
+			DeadLetter:  forwardDeadLetter,
+			Cursor:      forwardCursor,
+		}
+		if opts.Secret == "" {
+			opts.Secret = utils.WebhookSecret
+		}
+		if forwardTemplate != "" {
+			tmpl, err := utils.ParseWebhookTemplate(forwardTemplate)
+			if err != nil {
+				return fmt.Errorf("--template: %w", err)
+			}
+			opts.Template = tmpl
+		}
+
+		return utils.Forward(args, opts, verbose)
+	},
+}
+
+func init() {
+	forwardCmd.Flags().StringVar(&forwardWebhook, "webhook", "", "URL each message is POSTed to")
+	forwardCmd.Flags().StringVar(&forwardSecret, "secret", "", "Sign request bodies with this HMAC key (default: webhook-secret from pulse.conf)")
+	forwardCmd.Flags().StringVar(&forwardTemplate, "template", "", "Request body template, or @file to read it from a file")
+	forwardCmd.Flags().StringVar(&forwardContentType, "content-type", "application/json", "Content-Type of the request body")
+	forwardCmd.Flags().IntVar(&forwardRetries, "retries", 5, "Retries after a failed delivery")
+	forwardCmd.Flags().DurationVar(&forwardBackoff, "backoff", time.Second, "Delay before the first retry, doubled after each one")
+	forwardCmd.Flags().DurationVar(&forwardTimeout, "request-timeout", 10*time.Second, "Limit for each request")
+	forwardCmd.Flags().StringVar(&forwardDeadLetter, "dead-letter", "", "Append messages that could not be delivered to this file")
+	forwardCmd.Flags().StringVar(&forwardCursor, "cursor", "", "Resume after the last message handled under this name")
+	forwardCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Log each delivery and retry")
+	rootCmd.AddCommand(forwardCmd)
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var getCmd = &cobra.Command{
+	Use:   "get <id>",
+	Short: "Retrieve the most recent message (or latest value) for an ID",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return retrieveMessage(args[0], retrieveOptions())
+	},
+}
+
+func init() {
+	getCmd.Flags().BoolVar(&latest, "latest", false, "Look up the channel's replaceable latest value")
+	getCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Verbose output with relay status")
+	rootCmd.AddCommand(getCmd)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"pulse/utils"
+
+	"github.com/spf13/cobra"
+)
+
+var getFileCmd = &cobra.Command{
+	Use:   "get-file <id> [output]",
+	Short: "Download, verify and reassemble the newest file sent to an ID",
+	Long: `Download, verify and reassemble the newest file sent to an ID.
+
+The file is saved under the sender's file name unless an output path is
+given ("-" writes to stdout). Chunks are kept in pulse-data/transfers
+until the file verifies, so an interrupted download resumes where it
+stopped when run again.`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		output := ""
+		if len(args) > 1 {
+			output = args[1]
+		}
+		return utils.GetFile(args[0], output, verbose)
+	},
+}
+
+func init() {
+	getFileCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Verbose output with relay status")
+	rootCmd.AddCommand(getFileCmd)
+}
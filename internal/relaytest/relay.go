@@ -0,0 +1,245 @@
+// Package relaytest runs in-process nostr relays for tests
+package relaytest
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ws "github.com/coder/websocket"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Relay stores published events, answers subscriptions with the stored ones and pushes new
+// ones live
+type Relay struct {
+	server   *httptest.Server
+	URL      string // ws:// URL of the relay
+	MaxLimit int    // most stored events returned per filter, 0 = no cap
+
+	mu     sync.Mutex
+	events []*nostr.Event
+	conns  map[*conn]bool
+	down   bool
+}
+
+type conn struct {
+	ws   *ws.Conn
+	mu   sync.Mutex // serializes writes
+	subs map[string]nostr.Filters
+}
+
+func (c *conn) send(values ...any) {
+	data, _ := json.Marshal(values)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c.ws.Write(ctx, ws.MessageText, data)
+}
+
+// New starts a relay that is shut down when the test ends
+func New(t testing.TB) *Relay {
+	t.Helper()
+	r := &Relay{conns: make(map[*conn]bool)}
+	r.server = httptest.NewServer(http.HandlerFunc(r.serve))
+	r.URL = "ws" + strings.TrimPrefix(r.server.URL, "http")
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+func (r *Relay) serve(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	down := r.down
+	r.mu.Unlock()
+	if down {
+		http.Error(w, "relay is down", http.StatusServiceUnavailable)
+		return
+	}
+
+	if req.Header.Get("Accept") == "application/nostr+json" {
+		w.Header().Set("Content-Type", "application/nostr+json")
+		w.Write([]byte(`{"name":"fake","software":"relaytest","supported_nips":[1,11]}`))
+		return
+	}
+
+	wsConn, err := ws.Accept(w, req, nil)
+	if err != nil {
+		return
+	}
+	c := &conn{ws: wsConn, subs: make(map[string]nostr.Filters)}
+	r.mu.Lock()
+	r.conns[c] = true
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.conns, c)
+		r.mu.Unlock()
+		wsConn.CloseNow()
+	}()
+
+	for {
+		_, data, err := wsConn.Read(context.Background())
+		if err != nil {
+			return
+		}
+		var message []json.RawMessage
+		if json.Unmarshal(data, &message) != nil || len(message) < 2 {
+			continue
+		}
+		var label string
+		json.Unmarshal(message[0], &label)
+
+		switch label {
+		case "EVENT":
+			var ev nostr.Event
+			json.Unmarshal(message[1], &ev)
+			r.Publish(&ev)
+			c.send("OK", ev.ID, true, "")
+		case "REQ":
+			var id string
+			json.Unmarshal(message[1], &id)
+			var filters nostr.Filters
+			for _, raw := range message[2:] {
+				var filter nostr.Filter
+				json.Unmarshal(raw, &filter)
+				filters = append(filters, filter)
+			}
+			r.mu.Lock()
+			c.subs[id] = filters
+			stored := r.stored(filters)
+			r.mu.Unlock()
+			for _, ev := range stored {
+				c.send("EVENT", id, ev)
+			}
+			c.send("EOSE", id)
+		case "CLOSE":
+			var id string
+			json.Unmarshal(message[1], &id)
+			r.mu.Lock()
+			delete(c.subs, id)
+			r.mu.Unlock()
+		}
+	}
+}
+
+// stored returns the stored events matching the filters, newest first, each filter up to the
+// limit it asks for and MaxLimit, as relays answer a REQ
+func (r *Relay) stored(filters nostr.Filters) []*nostr.Event {
+	var stored []*nostr.Event
+	seen := make(map[string]bool)
+	for _, filter := range filters {
+		var matched []*nostr.Event
+		for _, ev := range r.events {
+			if filter.Matches(ev) {
+				matched = append(matched, ev)
+			}
+		}
+		slices.SortStableFunc(matched, func(a, b *nostr.Event) int {
+			return cmp.Compare(b.CreatedAt, a.CreatedAt)
+		})
+
+		limit := r.MaxLimit
+		if filter.Limit > 0 && (limit == 0 || filter.Limit < limit) {
+			limit = filter.Limit
+		}
+		if limit > 0 && len(matched) > limit {
+			matched = matched[:limit]
+		}
+		for _, ev := range matched {
+			if !seen[ev.ID] {
+				seen[ev.ID] = true
+				stored = append(stored, ev)
+			}
+		}
+	}
+	return stored
+}
+
+// Publish stores an event and sends it to every matching subscription
+func (r *Relay) Publish(ev *nostr.Event) {
+	type delivery struct {
+		conn *conn
+		sub  string
+	}
+	r.mu.Lock()
+	r.events = append(r.events, ev)
+	var deliveries []delivery
+	for c := range r.conns {
+		for id, filters := range c.subs {
+			if filters.Match(ev) {
+				deliveries = append(deliveries, delivery{c, id})
+			}
+		}
+	}
+	r.mu.Unlock()
+
+	for _, d := range deliveries {
+		d.conn.send("EVENT", d.sub, ev)
+	}
+}
+
+// Remove forgets a stored event, as a relay honoring its deletion would
+func (r *Relay) Remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = slices.DeleteFunc(r.events, func(ev *nostr.Event) bool { return ev.ID == id })
+}
+
+// Events returns the stored events matching a filter, in the order they were published
+func (r *Relay) Events(filter nostr.Filter) []*nostr.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var events []*nostr.Event
+	for _, ev := range r.events {
+		if filter.Matches(ev) {
+			events = append(events, ev)
+		}
+	}
+	return events
+}
+
+// SetDown makes the relay refuse new connections, as one that is offline, or accept them again
+// Connections already open are left alone
+func (r *Relay) SetDown(down bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.down = down
+}
+
+// OpenConnections returns how many WebSocket connections are open
+func (r *Relay) OpenConnections() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.conns)
+}
+
+// Subscriptions returns how many subscriptions are open across connections
+func (r *Relay) Subscriptions() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for c := range r.conns {
+		n += len(c.subs)
+	}
+	return n
+}
+
+// WaitFor polls until the condition holds, failing the test after a few seconds
+func WaitFor(t testing.TB, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
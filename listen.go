@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"pulse/utils"
+
+	"github.com/spf13/cobra"
+)
+
+var listenFollow bool
+var listenCount int
+var listenJSON bool
+var listenCursor string
+var listenMatch string
+var listenQuery string
+var listenFrom string
+var listenExec string
+var listenExecConcurrency int
+var listenExecTimeout time.Duration
+var listenExecReply bool
+
+var listenCmd = &cobra.Command{
+	Use:   "listen <id>...",
+	Short: "Print new messages on one or more IDs as they arrive",
+	Long: `Print new messages on one or more IDs as they arrive, one per line.
+
+Without --follow, listen exits after the first message (or --count
+messages). With --follow it streams every new message, deduplicated
+across relays, until interrupted or until --count is reached.
+
+Several IDs share one subscription per relay. Each line is then
+prefixed with its channel, as in "[deploys] finished".
+
+With --cursor, the last processed message is saved under that name in
+pulse-data/cursors. The next run first delivers, oldest first, every
+message stored since then, and then continues with live ones.
+
+--match, --jq and --from only pass messages whose body matches a
+regular expression, JSON bodies for which a jq-style expression is
+true, or messages signed by an author. Listen keeps waiting until
+enough matching messages arrive or the timeout expires. --jq supports
+paths, literals, == != < <= > >=, and, or and parentheses, e.g.
+  --jq '.status == "ok" and .duration < 60'
+
+With --exec, a command runs for each message instead of printing it.
+The message is passed on stdin, with PULSE_CHANNEL, PULSE_EVENT_ID,
+PULSE_AUTHOR and PULSE_CREATED_AT in the environment.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if listenCount < 0 {
+			return fmt.Errorf("--count cannot be negative")
+		}
+		if listenExec == "" && (listenExecReply || cmd.Flags().Changed("exec-concurrency") || listenExecTimeout != 0) {
+			return fmt.Errorf("--exec-concurrency, --exec-timeout and --reply require --exec")
+		}
+		if listenExec != "" && listenJSON {
+			return fmt.Errorf("--json cannot be combined with --exec")
+		}
+
+		opts := utils.ListenOptions{
+			Follow: listenFollow,
+			Count:  listenCount,
+			JSON:   listenJSON,
+			Cursor: listenCursor,
+
+			Exec:            listenExec,
+			ExecConcurrency: listenExecConcurrency,
+			ExecTimeout:     listenExecTimeout,
+			ExecReply:       listenExecReply,
+		}
+		if listenMatch != "" {
+			pattern, err := regexp.Compile(listenMatch)
+			if err != nil {
+				return fmt.Errorf("--match: %w", err)
+			}
+			opts.Match = pattern
+		}
+		if listenQuery != "" {
+			query, err := utils.ParseJSONQuery(listenQuery)
+			if err != nil {
+				return fmt.Errorf("--jq: %w", err)
+			}
+			opts.Query = query
+		}
+		if listenFrom != "" {
+			author, err := utils.ParsePublicKey(listenFrom)
+			if err != nil {
+				return fmt.Errorf("--from: %w", err)
+			}
+			opts.From = author
+		}
+
+		// Following runs until interrupted unless a timeout is given explicitly
+		timeoutSeconds := utils.ListenTimeout
+		if listenFollow {
+			timeoutSeconds = 0
+		}
+		if listenTimeout >= 0 {
+			timeoutSeconds = listenTimeout
+		}
+
+		opts.Timeout = time.Duration(timeoutSeconds) * time.Second
+
+		return utils.Listen(args, opts, verbose)
+	},
+}
+
+func init() {
+	listenCmd.Flags().BoolVarP(&listenFollow, "follow", "f", false, "Keep streaming messages until interrupted")
+	listenCmd.Flags().IntVarP(&listenCount, "count", "n", 0, "Exit after this many messages")
+	listenCmd.Flags().BoolVar(&listenJSON, "json", false, "Print each message as a JSON object")
+	listenCmd.Flags().StringVar(&listenCursor, "cursor", "", "Resume after the last message processed under this name")
+	listenCmd.Flags().StringVar(&listenMatch, "match", "", "Only deliver messages matching this regular expression")
+	listenCmd.Flags().StringVar(&listenQuery, "jq", "", "Only deliver JSON messages for which this expression is true")
+	listenCmd.Flags().StringVar(&listenFrom, "from", "", "Only deliver messages from this author (hex or npub)")
+	listenCmd.Flags().StringVar(&listenExec, "exec", "", "Run this command per message, with the message on stdin")
+	listenCmd.Flags().IntVar(&listenExecConcurrency, "exec-concurrency", 1, "Maximum commands running at once")
+	listenCmd.Flags().DurationVar(&listenExecTimeout, "exec-timeout", 0, "Kill a command after this long, e.g. 30s")
+	listenCmd.Flags().BoolVar(&listenExecReply, "reply", false, "Publish each command's stdout as a message on the channel")
+	listenCmd.Flags().IntVarP(&listenTimeout, "timeout", "t", -1, "Timeout in seconds (0 = none, -1 = config default, or none with --follow)")
+	listenCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Verbose output with relay status")
+	rootCmd.AddCommand(listenCmd)
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"pulse/utils"
 
@@ -16,6 +17,10 @@ var listenMode bool
 var verbose bool
 var generateConfig bool
 var listenTimeout int
+var ephemeral bool
+var latest bool
+var ttl time.Duration
+var once bool
 
 var rootCmd = &cobra.Command{
 	Use:   "pulse <id> [message]",
@@ -26,6 +31,7 @@ var rootCmd = &cobra.Command{
 		// Load config on startup
 		config := utils.LoadConfig()
 		utils.ApplyConfig(config)
+		utils.DataDir, _ = utils.GetDataDir()
 	},
 	RunE: func(cmd *cobra.Command, args []string) error {
 		// Handle --generate-config
@@ -40,6 +46,13 @@ var rootCmd = &cobra.Command{
 
 		id := args[0]
 
+		if err := checkSendFlags(); err != nil {
+			return err
+		}
+		if once && (chatMode || listenMode || len(args) < 2) {
+			return fmt.Errorf("--once only applies when sending a message")
+		}
+
 		// Check if message was provided before -c flag (error case)
 		if chatMode && len(args) > 1 {
 			// Find position of -c flag in os.Args
@@ -107,17 +120,17 @@ var rootCmd = &cobra.Command{
 				}
 			}
 
-			return utils.StartChat(id, username, verbose)
+			return utils.StartChat(id, username, sendOptions(), verbose)
 		}
 
 		// Send message mode
 		if len(args) > 1 {
 			message := args[1]
-			return utils.SendMessage(id, message, verbose)
+			return sendMessage(id, message, sendOptions())
 		}
 
 		// Retrieve mode (no message, no chat flag)
-		return utils.RetrieveMessage(id, verbose)
+		return retrieveMessage(id, retrieveOptions())
 	},
 }
 
@@ -127,6 +140,41 @@ func init() {
 	rootCmd.Flags().IntVarP(&listenTimeout, "listen-timeout", "t", -1, "Listen timeout in seconds (0 = no timeout, -1 = use config default)")
 	rootCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Verbose output with relay status")
 	rootCmd.Flags().BoolVarP(&generateConfig, "generate-config", "g", false, "Generate pulse.conf with default settings")
+	rootCmd.Flags().BoolVarP(&ephemeral, "ephemeral", "e", false, "Send as an ephemeral event that relays forward but never store")
+	rootCmd.Flags().BoolVar(&latest, "latest", false, "Send or retrieve the channel's replaceable latest value")
+	rootCmd.Flags().DurationVar(&ttl, "ttl", 0, "Expire sent messages after this long, e.g. 10m (send and chat mode)")
+	rootCmd.Flags().BoolVar(&once, "once", false, "Burn after reading: the first reader deletes the message")
+}
+
+// checkSendFlags rejects publishing flags that can't be combined
+func checkSendFlags() error {
+	if ephemeral && latest {
+		return fmt.Errorf("--ephemeral and --latest cannot be combined")
+	}
+	if once && (ephemeral || latest) {
+		return fmt.Errorf("--once cannot be combined with --ephemeral or --latest")
+	}
+	if ttl < 0 || (ttl > 0 && ttl < time.Second) {
+		return fmt.Errorf("--ttl must be at least 1s")
+	}
+	return nil
+}
+
+// sendOptions collects the publishing flags shared by send and chat mode
+func sendOptions() utils.SendOptions {
+	return utils.SendOptions{
+		Ephemeral: ephemeral,
+		Latest:    latest,
+		TTL:       ttl,
+		Once:      once,
+	}
+}
+
+// retrieveOptions collects the lookup flags shared by retrieve mode and get
+func retrieveOptions() utils.RetrieveOptions {
+	return utils.RetrieveOptions{
+		Latest: latest,
+	}
 }
 
 func main() {
@@ -0,0 +1,174 @@
+// Package pulse sends and receives encrypted Pulse channel messages from Go programs
+// Each Client carries its own configuration: it never reads pulse.conf, never changes the
+// settings of the pulse command or of other clients, and never prints anything
+package pulse
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"pulse/utils"
+)
+
+var (
+	ErrAlreadyRead = utils.ErrAlreadyRead // a burn-after-read message was consumed by another reader
+	ErrNoRelay     = utils.ErrNoRelay     // no relay accepted a published event
+	ErrNoMessages  = utils.ErrNoMessages  // a channel has no readable messages
+)
+
+// Client sends and reads messages on Pulse channels; it is safe for concurrent use
+type Client struct {
+	settings *utils.Settings
+	locks    utils.ChannelLocks // reads through this client never burn a one-time message twice
+}
+
+// Message is a decrypted channel message
+type Message struct {
+	ID        string    `json:"id"`
+	Channel   string    `json:"channel"`
+	Author    string    `json:"author"` // hex public key that signed the event
+	CreatedAt time.Time `json:"created_at"`
+	Kind      int       `json:"kind"`
+	Body      string    `json:"body"`
+	InReplyTo string    `json:"in_reply_to,omitempty"`
+}
+
+// SendOptions controls how a message is published
+type SendOptions struct {
+	Ephemeral bool          // relays forward the message but never store it
+	Latest    bool          // replace the channel's latest value instead of adding to its history
+	Once      bool          // burn after reading: the first reader deletes the message
+	TTL       time.Duration // expire the message after this long, 0 = never
+	InReplyTo string        // event ID of the message this one answers
+}
+
+// ReadOptions controls how a message is looked up
+type ReadOptions struct {
+	Latest bool // read the channel's replaceable latest value instead of its newest message
+}
+
+// BurnError is returned along with a burn-after-read message that was read but could not be
+// marked as read, so other readers may still see it
+type BurnError struct {
+	Err error
+}
+
+func (e *BurnError) Error() string {
+	return fmt.Sprintf("message read but not burned: %v", e.Err)
+}
+
+func (e *BurnError) Unwrap() error {
+	return e.Err
+}
+
+// New creates a client from the built-in defaults and the given options
+func New(opts ...Option) (*Client, error) {
+	c := &Client{settings: utils.DefaultSettings()}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+	if len(c.settings.Relays) == 0 && (len(c.settings.ReadRelays) == 0 || len(c.settings.WriteRelays) == 0) {
+		return nil, fmt.Errorf("pulse: no relays configured")
+	}
+	return c, nil
+}
+
+// Send encrypts a message and publishes it to the channel's write relays
+// Publish failures wrap ErrNoRelay; any other error means nothing was published
+func (c *Client) Send(ctx context.Context, channel string, body string, opts SendOptions) (*Message, error) {
+	ev, err := c.settings.SendMessage(ctx, channel, body, utils.SendOptions{
+		Ephemeral: opts.Ephemeral,
+		Latest:    opts.Latest,
+		Once:      opts.Once,
+		TTL:       opts.TTL,
+		InReplyTo: opts.InReplyTo,
+	}, false)
+	if err != nil {
+		return nil, err
+	}
+	return &Message{
+		ID:        ev.ID,
+		Channel:   channel,
+		Author:    ev.PubKey,
+		CreatedAt: ev.CreatedAt.Time(),
+		Kind:      ev.Kind,
+		Body:      body,
+		InReplyTo: opts.InReplyTo,
+	}, nil
+}
+
+// Latest reads the newest unexpired message of a channel, as "pulse get" does
+// A burn-after-read message is burned by reading it; if that fails the message is returned
+// together with a *BurnError
+func (c *Client) Latest(ctx context.Context, channel string, opts ReadOptions) (*Message, error) {
+	msg, burnErr, err := c.settings.ReadMessage(ctx, &c.locks, channel, utils.RetrieveOptions{Latest: opts.Latest}, false)
+	if err != nil {
+		return nil, err
+	}
+	if burnErr != nil {
+		return newMessage(msg), &BurnError{Err: burnErr}
+	}
+	return newMessage(msg), nil
+}
+
+// History returns up to limit of the newest stored messages of a channel, oldest first,
+// without burning any; a limit of 0 uses the client's history limit
+// The limit is asked of every relay, which may return fewer than that
+func (c *Client) History(ctx context.Context, channel string, limit int) ([]*Message, error) {
+	settings := *c.settings
+	if limit > 0 {
+		settings.HistoryLimit = limit
+	}
+	limit = settings.HistoryLimit
+	history, err := settings.History(ctx, channel, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(history) > limit {
+		history = history[len(history)-limit:]
+	}
+
+	messages := make([]*Message, len(history))
+	for i, msg := range history {
+		messages[i] = newMessage(msg)
+	}
+	return messages, nil
+}
+
+// Subscribe streams messages published on the channels from now on
+// The returned channel is closed once the context is cancelled and every relay connection
+// has been closed, or earlier if all relays drop the subscription
+func (c *Client) Subscribe(ctx context.Context, channels ...string) (<-chan *Message, error) {
+	if len(channels) == 0 {
+		return nil, fmt.Errorf("pulse: no channels to subscribe to")
+	}
+
+	messages := c.settings.SubscribeChannels(ctx, channels, false)
+	out := make(chan *Message)
+	go func() {
+		defer close(out)
+		// Keeps draining after cancellation, so out closes only once the relays are gone
+		for msg := range messages {
+			select {
+			case out <- newMessage(msg):
+			case <-ctx.Done():
+			}
+		}
+	}()
+	return out, nil
+}
+
+func newMessage(msg *utils.Message) *Message {
+	return &Message{
+		ID:        msg.ID,
+		Channel:   msg.Channel,
+		Author:    msg.Author,
+		CreatedAt: time.Unix(msg.CreatedAt, 0),
+		Kind:      msg.Kind,
+		Body:      msg.Body,
+		InReplyTo: msg.InReplyTo,
+	}
+}
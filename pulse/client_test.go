@@ -0,0 +1,108 @@
+package pulse
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"pulse/internal/relaytest"
+)
+
+// newTestClient returns a client reading from and publishing to the given relays
+func newTestClient(t *testing.T, relays ...string) *Client {
+	t.Helper()
+	c, err := New(WithRelays(relays...), WithSecret("client-test-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// deadRelay returns the URL of a relay that refuses connections
+func deadRelay(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "ws://" + listener.Addr().String()
+	listener.Close()
+	return url
+}
+
+func TestSendWithoutARelayFails(t *testing.T) {
+	dead := deadRelay(t)
+	c := newTestClient(t, dead)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	msg, err := c.Send(ctx, "dead-relay-test", "hello", SendOptions{})
+	if !errors.Is(err, ErrNoRelay) {
+		t.Fatalf("Send to a dead relay: err = %v, want ErrNoRelay", err)
+	}
+	if !strings.Contains(err.Error(), dead) {
+		t.Errorf("error %q doesn't name the relay", err)
+	}
+	if msg != nil {
+		t.Errorf("Send returned a message that was never published: %+v", msg)
+	}
+}
+
+func TestSendAndRead(t *testing.T) {
+	relay := relaytest.New(t)
+	c := newTestClient(t, relay.URL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sent, err := c.Send(ctx, "round-trip-test", "hello", SendOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	latest, err := c.Latest(ctx, "round-trip-test", ReadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.ID != sent.ID || latest.Body != "hello" {
+		t.Errorf("Latest = %+v, want the sent message", latest)
+	}
+
+	history, err := c.History(ctx, "round-trip-test", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].ID != sent.ID {
+		t.Errorf("History = %+v, want the sent message", history)
+	}
+}
+
+func TestLatestBurnsOnceMessages(t *testing.T) {
+	relay := relaytest.New(t)
+	sender, reader := newTestClient(t, relay.URL), newTestClient(t, relay.URL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := sender.Send(ctx, "once-test", "secret", SendOptions{Once: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := reader.Latest(ctx, "once-test", ReadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Body != "secret" {
+		t.Errorf("first read = %q, want secret", msg.Body)
+	}
+	if _, err := sender.Latest(ctx, "once-test", ReadOptions{}); !errors.Is(err, ErrAlreadyRead) {
+		t.Errorf("second read: err = %v, want ErrAlreadyRead", err)
+	}
+}
+
+func TestNewNeedsRelays(t *testing.T) {
+	if _, err := New(WithRelays()); err == nil {
+		t.Error("New without relays succeeded")
+	}
+}
@@ -0,0 +1,269 @@
+package pulse
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+
+	"pulse/utils"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Option configures a Client
+type Option func(*Client) error
+
+// Logger receives relay status lines as operations progress, such as "[✓] wss://nos.lol 42ms"
+// *log.Logger satisfies it
+type Logger interface {
+	Printf(format string, v ...any)
+}
+
+// ChannelConfig holds settings that apply to a single channel, as a [channel <id>] section
+// of pulse.conf does
+type ChannelConfig struct {
+	Relays      []string // relays for both reading and publishing, instead of the client's
+	ReadRelays  []string
+	WriteRelays []string
+	Kind        int  // event kind, 0 means the client's
+	ReadLegacy  bool // also read kind-1 notes for this channel
+	Ephemeral   bool // publish every message on this channel as ephemeral
+	Latest      bool // publish and read this channel as a replaceable latest value
+}
+
+// RelayConfig holds settings that apply to a single relay
+type RelayConfig struct {
+	AuthKey string // NIP-42 identity for this relay, hex or nsec
+	Proxy   string // proxy for this relay instead of the client's, "direct" bypasses it
+	Pow     int    // minimum NIP-13 difficulty, on top of what the relay advertises
+}
+
+// WithRelays sets the relays used for both reading and publishing
+func WithRelays(urls ...string) Option {
+	return func(c *Client) error {
+		c.settings.Relays = urls
+		return nil
+	}
+}
+
+// WithReadRelays sets the relays history is fetched from and subscriptions are made on
+func WithReadRelays(urls ...string) Option {
+	return func(c *Client) error {
+		c.settings.ReadRelays = urls
+		return nil
+	}
+}
+
+// WithWriteRelays sets the relays messages are published to
+func WithWriteRelays(urls ...string) Option {
+	return func(c *Client) error {
+		c.settings.WriteRelays = urls
+		return nil
+	}
+}
+
+// WithSecret sets the shared secret channel keys are derived from
+func WithSecret(secret string) Option {
+	return func(c *Client) error {
+		if secret == "" {
+			return fmt.Errorf("pulse: empty secret")
+		}
+		c.settings.UserSecret = secret
+		return nil
+	}
+}
+
+// WithIdentityKey signs every message with a persistent key, hex or nsec, instead of a fresh
+// key per message
+func WithIdentityKey(key string) Option {
+	return func(c *Client) error {
+		sk, err := utils.ParseSecretKey(key)
+		if err != nil {
+			return fmt.Errorf("pulse: identity key: %w", err)
+		}
+		c.settings.IdentityKey = sk
+		return nil
+	}
+}
+
+// WithAuthKey sets the NIP-42 identity, hex or nsec, for relays without their own
+func WithAuthKey(key string) Option {
+	return func(c *Client) error {
+		sk, err := utils.ParseSecretKey(key)
+		if err != nil {
+			return fmt.Errorf("pulse: auth key: %w", err)
+		}
+		c.settings.AuthKey = sk
+		return nil
+	}
+}
+
+// WithHistoryLimit sets how many messages are fetched from each relay
+func WithHistoryLimit(limit int) Option {
+	return func(c *Client) error {
+		if limit < 1 {
+			return fmt.Errorf("pulse: invalid history limit %d", limit)
+		}
+		c.settings.HistoryLimit = limit
+		return nil
+	}
+}
+
+// WithEventKind sets the regular kind messages are published as
+func WithEventKind(kind int) Option {
+	return func(c *Client) error {
+		if _, err := utils.ParseEventKind(strconv.Itoa(kind)); err != nil {
+			return fmt.Errorf("pulse: %w", err)
+		}
+		c.settings.EventKind = kind
+		return nil
+	}
+}
+
+// WithEphemeralKind sets the kind ephemeral messages are published as
+func WithEphemeralKind(kind int) Option {
+	return func(c *Client) error {
+		if _, err := utils.ParseEphemeralKind(strconv.Itoa(kind)); err != nil {
+			return fmt.Errorf("pulse: %w", err)
+		}
+		c.settings.EphemeralKind = kind
+		return nil
+	}
+}
+
+// WithLatestKind sets the addressable kind latest values are published as
+func WithLatestKind(kind int) Option {
+	return func(c *Client) error {
+		if _, err := utils.ParseLatestKind(strconv.Itoa(kind)); err != nil {
+			return fmt.Errorf("pulse: %w", err)
+		}
+		c.settings.LatestKind = kind
+		return nil
+	}
+}
+
+// WithReadLegacy also reads kind-1 notes written by older versions
+func WithReadLegacy(enabled bool) Option {
+	return func(c *Client) error {
+		c.settings.ReadLegacy = enabled
+		return nil
+	}
+}
+
+// WithCompression sets the compression applied to message bodies, "deflate" or "none"
+func WithCompression(algorithm string) Option {
+	return func(c *Client) error {
+		if _, err := utils.ParseCompression(algorithm); err != nil {
+			return fmt.Errorf("pulse: %w", err)
+		}
+		c.settings.Compression = algorithm
+		return nil
+	}
+}
+
+// WithPow mines NIP-13 proof-of-work of this difficulty for every published event
+func WithPow(difficulty int) Option {
+	return func(c *Client) error {
+		if _, err := utils.ParsePowDifficulty(strconv.Itoa(difficulty)); err != nil {
+			return fmt.Errorf("pulse: %w", err)
+		}
+		c.settings.Pow = difficulty
+		return nil
+	}
+}
+
+// WithMaxPow caps the difficulty mined for a relay's advertised requirement
+func WithMaxPow(difficulty int) Option {
+	return func(c *Client) error {
+		if _, err := utils.ParsePowDifficulty(strconv.Itoa(difficulty)); err != nil {
+			return fmt.Errorf("pulse: %w", err)
+		}
+		c.settings.MaxPow = difficulty
+		return nil
+	}
+}
+
+// WithMaxMessageSize limits how large a message body may grow when decompressed
+func WithMaxMessageSize(size int) Option {
+	return func(c *Client) error {
+		if size < 1 {
+			return fmt.Errorf("pulse: invalid max message size %d", size)
+		}
+		c.settings.MaxMessageSize = size
+		return nil
+	}
+}
+
+// WithChannel sets the configuration of one channel
+func WithChannel(id string, config ChannelConfig) Option {
+	return func(c *Client) error {
+		if config.Kind != 0 {
+			if _, err := utils.ParseEventKind(strconv.Itoa(config.Kind)); err != nil {
+				return fmt.Errorf("pulse: channel %s: %w", id, err)
+			}
+		}
+		c.settings.Channels[id] = &utils.ChannelConfig{
+			Relays:      slices.Clone(config.Relays),
+			ReadRelays:  slices.Clone(config.ReadRelays),
+			WriteRelays: slices.Clone(config.WriteRelays),
+			Kind:        config.Kind,
+			ReadLegacy:  config.ReadLegacy,
+			Ephemeral:   config.Ephemeral,
+			Latest:      config.Latest,
+		}
+		return nil
+	}
+}
+
+// WithRelay sets the configuration of one relay
+func WithRelay(url string, config RelayConfig) Option {
+	return func(c *Client) error {
+		if config.Proxy != "" {
+			if _, err := utils.ParseProxy(config.Proxy); err != nil {
+				return fmt.Errorf("pulse: %s: %w", url, err)
+			}
+		}
+		rc := &utils.RelayConfig{Proxy: config.Proxy, Pow: config.Pow}
+		if config.AuthKey != "" {
+			sk, err := utils.ParseSecretKey(config.AuthKey)
+			if err != nil {
+				return fmt.Errorf("pulse: %s: auth key: %w", url, err)
+			}
+			rc.AuthKey = sk
+		}
+		c.settings.RelayConfigs[nostr.NormalizeURL(url)] = rc
+		return nil
+	}
+}
+
+// WithProxy sends relay WebSocket connections and NIP-11 fetches through a socks5://, http://
+// or https:// proxy, such as Tor; each client has its own connections to it
+func WithProxy(proxy string) Option {
+	return func(c *Client) error {
+		if proxy == utils.ProxyDirect {
+			return fmt.Errorf("pulse: %q only applies to a single relay", proxy)
+		}
+		if _, err := utils.ParseProxy(proxy); err != nil {
+			return fmt.Errorf("pulse: %w", err)
+		}
+		c.settings.Proxy = proxy
+		return nil
+	}
+}
+
+// WithDataDir caches relay NIP-11 documents in a directory between runs; by default they
+// are only kept in memory
+func WithDataDir(dir string) Option {
+	return func(c *Client) error {
+		c.settings.DataDir = dir
+		return nil
+	}
+}
+
+// WithLogger reports relay status to a logger; by default it is discarded
+func WithLogger(logger Logger) Option {
+	return func(c *Client) error {
+		c.settings.Log = logger.Printf
+		return nil
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"pulse/utils"
+
+	"github.com/spf13/cobra"
+)
+
+var relaysJSON bool
+
+var relaysCmd = &cobra.Command{
+	Use:   "relays",
+	Short: "Inspect configured relays",
+}
+
+var relaysTestCmd = &cobra.Command{
+	Use:   "test [relay...]",
+	Short: "Check connectivity, NIP-11 info and publish/read-back on each relay",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return utils.TestRelays(args, verbose, relaysJSON)
+	},
+}
+
+func init() {
+	relaysTestCmd.Flags().BoolVar(&relaysJSON, "json", false, "Output results as JSON")
+	relaysTestCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Show per-step results for each relay")
+	relaysCmd.AddCommand(relaysTestCmd)
+	rootCmd.AddCommand(relaysCmd)
+}
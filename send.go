@@ -0,0 +1,31 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var sendCmd = &cobra.Command{
+	Use:   "send <id> <message>",
+	Short: "Send a message to an ID",
+	Long: `Send a message to an ID.
+
+With --once the message is burned after reading: the first "pulse get"
+displays it, then acknowledges and deletes it so later readers get a
+"message already read" error.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkSendFlags(); err != nil {
+			return err
+		}
+		return sendMessage(args[0], args[1], sendOptions())
+	},
+}
+
+func init() {
+	sendCmd.Flags().BoolVar(&once, "once", false, "Burn after reading: the first reader deletes the message")
+	sendCmd.Flags().DurationVar(&ttl, "ttl", 0, "Expire the message after this long, e.g. 10m")
+	sendCmd.Flags().BoolVarP(&ephemeral, "ephemeral", "e", false, "Send as an ephemeral event that relays forward but never store")
+	sendCmd.Flags().BoolVar(&latest, "latest", false, "Send as the channel's replaceable latest value")
+	sendCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Verbose output with relay status")
+	rootCmd.AddCommand(sendCmd)
+}
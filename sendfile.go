@@ -0,0 +1,27 @@
+package main
+
+import (
+	"pulse/utils"
+
+	"github.com/spf13/cobra"
+)
+
+var sendFileCmd = &cobra.Command{
+	Use:   "send-file <id> <path>",
+	Short: "Send a file as compressed, encrypted chunks",
+	Long: `Send a file as compressed, encrypted chunks.
+
+The file is gzip-compressed, encrypted and split into numbered chunk
+events that fit relay size limits. A manifest with the file's name, size,
+SHA-256 hash and chunk count is published once every chunk is stored.
+Receive it with "pulse get-file <id>".`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return utils.SendFile(args[0], args[1], verbose)
+	},
+}
+
+func init() {
+	sendFileCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Verbose output with relay status")
+	rootCmd.AddCommand(sendFileCmd)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+
+	"pulse/utils"
+
+	"github.com/spf13/cobra"
+)
+
+var serveExec string
+var serveConcurrency int
+
+var serveCmd = &cobra.Command{
+	Use:   "serve <id> --exec <handler>",
+	Short: "Answer requests from pulse call by running a handler",
+	Long: `Answer requests from "pulse call" by running a handler.
+
+The handler runs once per request with the request body on stdin and
+PULSE_CHANNEL and PULSE_REQUEST_ID in its environment. Its stdout is
+published as the response; a non-zero exit is reported to the caller.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if serveExec == "" {
+			return fmt.Errorf("--exec is required")
+		}
+		if serveConcurrency < 1 {
+			return fmt.Errorf("--concurrency must be at least 1")
+		}
+		return utils.Serve(args[0], serveExec, serveConcurrency, verbose)
+	},
+}
+
+func init() {
+	serveCmd.Flags().StringVar(&serveExec, "exec", "", "Handler command to run for each request")
+	serveCmd.Flags().IntVar(&serveConcurrency, "concurrency", 4, "Maximum handlers running at once")
+	serveCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Verbose output with relay status")
+	rootCmd.AddCommand(serveCmd)
+}
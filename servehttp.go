@@ -0,0 +1,42 @@
+package main
+
+import (
+	"pulse/utils"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	serveHTTPListen string
+	serveHTTPToken  string
+)
+
+var serveHTTPCmd = &cobra.Command{
+	Use:   "serve-http",
+	Short: "Serve channels over a local HTTP API",
+	Long: `Serve channels over HTTP for programs that would rather not run the binary:
+
+  POST /channels/{id}/messages        send {"message": "...", "once": true, "ttl": "10m", ...}
+  GET  /channels/{id}/messages?limit= stored messages, oldest first
+  GET  /channels/{id}/latest          the message get would show (?latest=true for latest values)
+  GET  /channels/{id}/stream          live messages as Server-Sent Events
+
+Every request needs "Authorization: Bearer <token>" (or ?access_token= for
+EventSource). The token comes from --token or http-token in pulse.conf;
+without either a random one is generated and printed at startup.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		token := serveHTTPToken
+		if token == "" {
+			token = utils.HTTPToken
+		}
+		return utils.RunHTTPGateway(serveHTTPListen, token, verbose)
+	},
+}
+
+func init() {
+	serveHTTPCmd.Flags().StringVar(&serveHTTPListen, "listen", "127.0.0.1:8787", "Address to listen on")
+	serveHTTPCmd.Flags().StringVar(&serveHTTPToken, "token", "", "Bearer token clients must send (default: http-token from pulse.conf)")
+	serveHTTPCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Log each request")
+	rootCmd.AddCommand(serveHTTPCmd)
+}
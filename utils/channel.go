@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// ChannelConfig holds settings that apply to a single channel ID
+type ChannelConfig struct {
+	Relays      []string
+	ReadRelays  []string
+	WriteRelays []string
+	Kind        int  // event kind, 0 means the global EventKind
+	ReadLegacy  bool // also read kind-1 notes for this channel
+	Ephemeral   bool // publish every message on this channel as ephemeral
+	Latest      bool // publish and read this channel as a replaceable latest value
+}
+
+var ReadRelays []string  // relays to subscribe to, defaults to Relays
+var WriteRelays []string // relays to publish to, defaults to Relays
+
+var ChannelSettings = map[string]*ChannelConfig{}
+
+// ChannelConfig returns the settings for a channel, or empty settings if none are configured
+func (s *Settings) ChannelConfig(id string) *ChannelConfig {
+	if cc, ok := s.Channels[id]; ok {
+		return cc
+	}
+	return &ChannelConfig{}
+}
+
+// RelaysForReading is a wrapper around CurrentSettings().RelaysForReading
+func RelaysForReading(id string) []string {
+	return CurrentSettings().RelaysForReading(id)
+}
+
+// RelaysForReading returns the relays to fetch history and subscribe on for a channel
+func (s *Settings) RelaysForReading(id string) []string {
+	cc := s.ChannelConfig(id)
+	return firstRelayList(cc.ReadRelays, cc.Relays, s.ReadRelays, s.Relays)
+}
+
+// RelaysForWriting is a wrapper around CurrentSettings().RelaysForWriting
+func RelaysForWriting(id string) []string {
+	return CurrentSettings().RelaysForWriting(id)
+}
+
+// RelaysForWriting returns the relays to publish to for a channel
+func (s *Settings) RelaysForWriting(id string) []string {
+	cc := s.ChannelConfig(id)
+	return firstRelayList(cc.WriteRelays, cc.Relays, s.WriteRelays, s.Relays)
+}
+
+// MessageKind returns the event kind a channel's messages are published with
+func (s *Settings) MessageKind(id string) int {
+	if kind := s.ChannelConfig(id).Kind; kind != 0 {
+		return kind
+	}
+	return s.EventKind
+}
+
+// ReadKinds is a wrapper around CurrentSettings().ReadKinds
+func ReadKinds(id string) []int {
+	return CurrentSettings().ReadKinds(id)
+}
+
+// ReadKinds returns the event kinds to query for a channel, including kind 1 in migration mode
+func (s *Settings) ReadKinds(id string) []int {
+	kind := s.MessageKind(id)
+	if (s.ReadLegacy || s.ChannelConfig(id).ReadLegacy) && kind != nostr.KindTextNote {
+		return []int{kind, nostr.KindTextNote}
+	}
+	return []int{kind}
+}
+
+// ParseEventKind validates a configured event kind, which must be a regular (stored) kind
+func ParseEventKind(value string) (int, error) {
+	kind, err := strconv.Atoi(value)
+	if err != nil || !nostr.IsRegularKind(kind) {
+		return 0, fmt.Errorf("invalid event kind %q, expected a regular kind such as %d", value, KindPulseMessage)
+	}
+	return kind, nil
+}
+
+// ParseEphemeralKind validates a configured ephemeral kind (20000-29999)
+func ParseEphemeralKind(value string) (int, error) {
+	kind, err := strconv.Atoi(value)
+	if err != nil || !nostr.IsEphemeralKind(kind) {
+		return 0, fmt.Errorf("invalid ephemeral kind %q, expected 20000-29999", value)
+	}
+	return kind, nil
+}
+
+// ParseLatestKind validates a configured latest-value kind, which must be addressable (30000-39999)
+func ParseLatestKind(value string) (int, error) {
+	kind, err := strconv.Atoi(value)
+	if err != nil || !nostr.IsAddressableKind(kind) {
+		return 0, fmt.Errorf("invalid latest kind %q, expected 30000-39999", value)
+	}
+	return kind, nil
+}
+
+// AllRelays returns every configured relay once, in configuration order
+func AllRelays() []string {
+	lists := [][]string{Relays, ReadRelays, WriteRelays}
+	for _, cc := range ChannelSettings {
+		lists = append(lists, cc.Relays, cc.ReadRelays, cc.WriteRelays)
+	}
+
+	seen := make(map[string]bool)
+	var all []string
+	for _, list := range lists {
+		for _, url := range list {
+			normalized := nostr.NormalizeURL(url)
+			if !seen[normalized] {
+				seen[normalized] = true
+				all = append(all, url)
+			}
+		}
+	}
+	return all
+}
+
+// ParseRelayList splits a comma-separated relay list, dropping empty entries
+func ParseRelayList(value string) []string {
+	relays := []string{}
+	for _, relay := range strings.Split(value, ",") {
+		relay = strings.TrimSpace(relay)
+		if relay != "" {
+			relays = append(relays, relay)
+		}
+	}
+	return relays
+}
+
+func firstRelayList(lists ...[]string) []string {
+	for _, list := range lists {
+		if len(list) > 0 {
+			return list
+		}
+	}
+	return nil
+}
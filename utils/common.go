@@ -7,9 +7,12 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -22,9 +25,57 @@ var ListenTimeout = 30 // in seconds, 0 = no timeout
 
 var Relays = []string{"wss://relay.damus.io", "wss://nos.lol", "wss://relay.snort.social"}
 
-// DeriveKey creates an encryption key from an ID and the user secret
+// KindPulseMessage is the application-specific regular kind Pulse publishes by default
+const KindPulseMessage = 4242
+
+// KindPulseEphemeral is the ephemeral kind used for messages relays forward but never store
+const KindPulseEphemeral = 21242
+
+var IdentityKey = "" // persistent signing key, empty means a fresh key per message
+
+var EventKind = KindPulseMessage
+var EphemeralKind = KindPulseEphemeral
+var ReadLegacyKind = false // also read kind-1 notes written by older versions
+
+var ErrNoRelay = errors.New("no relay accepts this event")
+
+// SendOptions controls how an outgoing message is published
+type SendOptions struct {
+	Ephemeral bool          // publish with the ephemeral kind so only live subscribers see it
+	Latest    bool          // publish as the channel's replaceable latest value
+	TTL       time.Duration // expire the message after this long (NIP-40), 0 = never
+	Once      bool          // burn after reading: the first reader acknowledges and deletes it
+	Relays    []string      // publish targets, defaults to the channel's write relays
+	RPC       *RPCHeader    // request or response metadata for call and serve
+	InReplyTo string        // event ID of the message being answered
+}
+
+// writeRelays returns the relays a message for the channel is published to
+func (o SendOptions) writeRelays(id string) []string {
+	return o.relays(CurrentSettings(), id)
+}
+
+func (o SendOptions) relays(s *Settings, id string) []string {
+	if len(o.Relays) > 0 {
+		return o.Relays
+	}
+	return s.RelaysForWriting(id)
+}
+
+// History is the stored state of a channel as seen across relays
+type History struct {
+	Messages []*nostr.Event // oldest to newest, without retracted or already-read messages
+	Acks     []*nostr.Event // read acknowledgments that burned one of the fetched burn-after-read messages
+}
+
+// DeriveKey is a wrapper around CurrentSettings().DeriveKey
 func DeriveKey(id string) []byte {
-	h := sha256.Sum256([]byte(id + UserSecret))
+	return CurrentSettings().DeriveKey(id)
+}
+
+// DeriveKey creates an encryption key from an ID and the user secret
+func (s *Settings) DeriveKey(id string) []byte {
+	h := sha256.Sum256([]byte(id + s.UserSecret))
 	return h[:]
 }
 
@@ -54,56 +105,248 @@ func Decrypt(hexData string, key []byte) (string, error) {
 	return string(plaintext), err
 }
 
-// FetchHistory retrieves historical messages from relays
-func FetchHistory(ctx context.Context, id string, key []byte, verbose bool) ([]*nostr.Event, error) {
+// SigningKey is a wrapper around CurrentSettings().SigningKey
+func SigningKey() string {
+	return CurrentSettings().SigningKey()
+}
+
+// SigningKey returns the key messages are signed with: the configured identity, or a throwaway key
+func (s *Settings) SigningKey() string {
+	if s.IdentityKey != "" {
+		return s.IdentityKey
+	}
+	return nostr.GeneratePrivateKey()
+}
+
+// NewMessageEvent is a wrapper around CurrentSettings().NewMessageEvent
+func NewMessageEvent(ctx context.Context, id string, key []byte, message string, sk string, opts SendOptions, verbose bool) (nostr.Event, error) {
+	return CurrentSettings().NewMessageEvent(ctx, id, key, message, sk, opts, verbose)
+}
+
+// NewMessageEvent encrypts a message for a channel and builds the signed event carrying it
+// Proof-of-work required by the publish targets is mined before signing
+func (s *Settings) NewMessageEvent(ctx context.Context, id string, key []byte, message string, sk string, opts SendOptions, verbose bool) (nostr.Event, error) {
+	if err := s.checkSendOptions(id, opts); err != nil {
+		return nostr.Event{}, err
+	}
+
+	now := nostr.Now()
+	env := &Envelope{Body: message, InReplyTo: opts.InReplyTo}
+	if opts.TTL > 0 {
+		env.Expires = int64(now) + int64(opts.TTL.Seconds())
+	}
+	if opts.Once {
+		// Signing with a key we hand to the reader lets them delete the message afterwards
+		sk = nostr.GeneratePrivateKey()
+		env.BurnKey = sk
+	}
+
+	// Responses go out on the reply tag the caller is subscribed to, not the channel tag
 	hashedTag := hex.EncodeToString(key)
+	if opts.RPC != nil {
+		env.RPC = opts.RPC
+		if opts.RPC.Response {
+			hashedTag = opts.RPC.ReplyTag
+		}
+	}
+
+	encrypted, err := s.SealMessage(env, key)
+	if err != nil {
+		return nostr.Event{}, err
+	}
+
+	var ev nostr.Event
+	if !opts.Ephemeral && opts.RPC == nil && s.IsLatestChannel(id, opts.Latest) {
+		sk, ev = s.latestEvent(key, encrypted)
+	} else {
+		kind := s.MessageKind(id)
+		if opts.Ephemeral || s.ChannelConfig(id).Ephemeral {
+			kind = s.EphemeralKind
+		}
+		ev = nostr.Event{
+			Kind:    kind,
+			Tags:    nostr.Tags{{"t", hashedTag}},
+			Content: encrypted,
+		}
+	}
+
+	ev.CreatedAt = now
+	if env.Expires != 0 {
+		ev.Tags = append(ev.Tags, nostr.Tag{"expiration", strconv.FormatInt(env.Expires, 10)})
+	}
+	if err := s.SignEvent(ctx, &ev, sk, opts.relays(s, id), verbose); err != nil {
+		return nostr.Event{}, err
+	}
+	return ev, nil
+}
+
+// checkSendOptions rejects options that can't be combined on a channel
+func (s *Settings) checkSendOptions(id string, opts SendOptions) error {
+	if opts.Once && (s.IsLatestChannel(id, opts.Latest) || opts.Ephemeral || s.ChannelConfig(id).Ephemeral) {
+		// The reader consumes a stored message, so it can't be replaced or go unstored
+		return fmt.Errorf("burn-after-read messages cannot be latest-value or ephemeral")
+	}
+	return nil
+}
+
+// MessageFilter is a wrapper around CurrentSettings().MessageFilter
+func MessageFilter(id string, key []byte) nostr.Filter {
+	return CurrentSettings().MessageFilter(id, key)
+}
+
+// MessageFilter returns the filter matching a channel's stored messages
+func (s *Settings) MessageFilter(id string, key []byte) nostr.Filter {
+	return nostr.Filter{
+		Tags:  nostr.TagMap{"t": []string{hex.EncodeToString(key)}},
+		Kinds: s.ReadKinds(id),
+	}
+}
+
+// LiveFilter is a wrapper around CurrentSettings().LiveFilter
+func LiveFilter(id string, key []byte) nostr.Filter {
+	return CurrentSettings().LiveFilter(id, key)
+}
+
+// LiveFilter returns the filter for live subscriptions, which also carry ephemeral messages
+// and latest-value updates
+func (s *Settings) LiveFilter(id string, key []byte) nostr.Filter {
+	filter := s.MessageFilter(id, key)
+	filter.Kinds = append(filter.Kinds, s.EphemeralKind, s.LatestKind)
+	now := nostr.Now()
+	filter.Since = &now
+	return filter
+}
+
+// FetchHistory is a wrapper around CurrentSettings().FetchHistory
+func FetchHistory(ctx context.Context, id string, key []byte, verbose bool) (*History, error) {
+	return CurrentSettings().FetchHistory(ctx, id, key, verbose)
+}
+
+// FetchHistory retrieves historical messages from relays
+// Inside the daemon, channels read before are answered from its message store
+func (s *Settings) FetchHistory(ctx context.Context, id string, key []byte, verbose bool) (*History, error) {
+	// The store holds what relays returned for history-limit, so larger limits go to the relays
+	if s.store != nil && s.HistoryLimit <= HistoryLimit {
+		return s.store.History(ctx, id, key)
+	}
+	messages, deletions, acks := s.fetchHistoryEvents(ctx, id, key, verbose)
+	return s.newHistory(key, messages, deletions, acks), nil
+}
+
+// fetchHistoryEvents reads a channel's stored messages, deletion requests and read acks from relays
+func (s *Settings) fetchHistoryEvents(ctx context.Context, id string, key []byte, verbose bool) (messages, deletions, acks []*nostr.Event) {
 	var allHistory []*nostr.Event
+	seenHistory := make(map[string]bool)
 	var histMu sync.Mutex
+	collect := func(ev *nostr.Event) {
+		// The same event usually arrives from several relays
+		histMu.Lock()
+		defer histMu.Unlock()
+		if seenHistory[ev.ID] {
+			return
+		}
+		seenHistory[ev.ID] = true
+		switch ev.Kind {
+		case nostr.KindDeletion:
+			deletions = append(deletions, ev)
+		case KindPulseAck:
+			acks = append(acks, ev)
+		default:
+			allHistory = append(allHistory, ev)
+		}
+	}
 	var wg sync.WaitGroup
 
-	tracker := NewStatusTracker(verbose)
+	tracker := s.newStatusTracker(verbose)
 
 	// Create a longer timeout context for relay connections + message wait
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	for _, url := range Relays {
+	for _, url := range s.RelaysForReading(id) {
 		tracker.AddRelay(url)
 		wg.Add(1)
 		go func(u string) {
 			defer wg.Done()
 			tracker.UpdateStatus(u, "pending")
-			r, err := nostr.RelayConnect(ctx, u)
+			r, err := s.ConnectRelay(ctx, u)
 			if err != nil {
 				tracker.UpdateStatusWithReason(u, "error", err.Error())
 				return
 			}
 
-			sub, _ := r.Subscribe(ctx, []nostr.Filter{{
-				Tags:  nostr.TagMap{"t": []string{hashedTag}},
-				Kinds: []int{nostr.KindTextNote},
-				Limit: HistoryLimit,
-			}})
+			// Deletion requests and read acks for the channel come back in the same subscription
+			filter := s.MessageFilter(id, key)
+			filter.Limit = s.HistoryLimit
+			sub, err := r.Subscribe(ctx, []nostr.Filter{filter, ControlFilter(key)})
+			if err != nil {
+				tracker.UpdateStatusWithReason(u, "error", err.Error())
+				return
+			}
 
-			// Wait maximum 300ms for messages from this relay
+			// Collect stored messages until EOSE, waiting maximum 300ms for this relay
+			received := 0
+			oldestMessage := nostr.Now()
+			oldestControl := nostr.Now()
+			controls := make(map[string]bool)
 			timeout := time.After(300 * time.Millisecond)
 		Loop:
 			for {
 				select {
-				case ev := <-sub.Events:
-					histMu.Lock()
-					allHistory = append(allHistory, ev)
-					histMu.Unlock()
-					tracker.UpdateStatusWithReason(u, "success", "message retrieved")
+				case reason := <-sub.ClosedReason:
+					sub, err = s.ResubscribeAfterClosed(ctx, r, u, sub, reason, tracker)
+					if err != nil {
+						tracker.UpdateStatusWithReason(u, "error", err.Error())
+						return
+					}
+				case ev, ok := <-sub.Events:
+					if !ok {
+						break Loop
+					}
+					collect(ev)
+					if ev.Kind == nostr.KindDeletion || ev.Kind == KindPulseAck {
+						controls[ev.ID] = true
+						oldestControl = min(oldestControl, ev.CreatedAt)
+					} else {
+						received++
+						oldestMessage = min(oldestMessage, ev.CreatedAt)
+					}
+				case <-sub.EndOfStoredEvents:
 					break Loop
 				case <-timeout:
-					tracker.UpdateStatusWithReason(u, "cancelled", "300ms timeout reached")
+					if received == 0 {
+						tracker.UpdateStatusWithReason(u, "cancelled", "300ms timeout reached")
+						return
+					}
 					break Loop
 				case <-ctx.Done():
 					tracker.UpdateStatusWithReason(u, "cancelled", "context cancelled")
-					break Loop
+					return
+				}
+			}
+
+			// Controls come back capped like messages, so older ones are paged in while they may
+			// still concern the messages received: a control always comes after its message
+			if len(controls) > 0 {
+				notBefore := oldestMessage
+				if received == 0 {
+					notBefore = 0
+				}
+				older := ControlFilter(key)
+				older.Until = &oldestControl
+				for _, ev := range s.pageBack(ctx, r, u, older, notBefore, controls, tracker) {
+					collect(ev)
 				}
 			}
+
+			switch received {
+			case 0:
+				tracker.UpdateStatusWithReason(u, "success", "no messages")
+			case 1:
+				tracker.UpdateStatusWithReason(u, "success", "message retrieved")
+			default:
+				tracker.UpdateStatusWithReason(u, "success", fmt.Sprintf("%d messages retrieved", received))
+			}
 		}(url)
 	}
 	wg.Wait()
@@ -114,38 +357,221 @@ func FetchHistory(ctx context.Context, id string, key []byte, verbose bool) ([]*
 		fmt.Printf("Total time: %dms\n", tracker.GetTotalDuration().Milliseconds())
 	}
 
+	return allHistory, deletions, acks
+}
+
+// pageBack reads a relay's events for a filter page by page, each page ending at the oldest
+// event of the one before, and returns those not already in seen
+// Paging stops when a page brings no new events or reaches back past notBefore; a short page
+// doesn't mean the end, since the relay may cap it below the requested limit
+func (s *Settings) pageBack(ctx context.Context, r *nostr.Relay, url string, filter nostr.Filter, notBefore nostr.Timestamp, seen map[string]bool, tracker *StatusTracker) []*nostr.Event {
+	var events []*nostr.Event
+	for ctx.Err() == nil && (filter.Until == nil || *filter.Until >= notBefore) {
+		pageCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		page, err := s.querySyncWithAuth(pageCtx, r, url, filter, tracker)
+		cancel()
+		if err != nil {
+			break
+		}
+
+		added := 0
+		oldest := nostr.Now()
+		for _, ev := range page {
+			oldest = min(oldest, ev.CreatedAt)
+			if !seen[ev.ID] {
+				seen[ev.ID] = true
+				events = append(events, ev)
+				added++
+			}
+		}
+		if added == 0 {
+			break
+		}
+		// Inclusive, so events sharing the oldest second aren't skipped
+		filter.Until = &oldest
+	}
+	return events
+}
+
+// newHistory builds a channel's history from the events read for it
+// The message slice is filtered in place
+func (s *Settings) newHistory(key []byte, messages, deletions, acks []*nostr.Event) *History {
+	// Retracted and already-read messages never make it into history
+	messages, acks = s.ApplyAcks(key, messages, acks)
+	messages = ApplyDeletions(messages, deletions)
+
 	// Sort History: Oldest to Newest
-	sort.Slice(allHistory, func(i, j int) bool {
-		return allHistory[i].CreatedAt < allHistory[j].CreatedAt
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].CreatedAt < messages[j].CreatedAt
 	})
 
-	return allHistory, nil
+	return &History{Messages: messages, Acks: acks}
+}
+
+// QueryRelays is a wrapper around CurrentSettings().QueryRelays
+func QueryRelays(ctx context.Context, relays []string, filter nostr.Filter, verbose bool) ([]*nostr.Event, error) {
+	return CurrentSettings().QueryRelays(ctx, relays, filter, verbose)
 }
 
-// PublishEvent publishes an event to all relays
-func PublishEvent(ctx context.Context, event nostr.Event, verbose bool) error {
+// QueryRelays reads every stored event matching a filter from the given relays, deduplicated
+// Each relay is read until EOSE, so the result doesn't depend on a timing window
+func (s *Settings) QueryRelays(ctx context.Context, relays []string, filter nostr.Filter, verbose bool) ([]*nostr.Event, error) {
+	var all []*nostr.Event
+	seen := make(map[string]bool)
+	var mu sync.Mutex
 	var wg sync.WaitGroup
 
-	tracker := NewStatusTracker(verbose)
+	tracker := s.newStatusTracker(verbose)
 
-	for _, url := range Relays {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	for _, url := range relays {
 		tracker.AddRelay(url)
 		wg.Add(1)
 		go func(u string) {
 			defer wg.Done()
 			tracker.UpdateStatus(u, "pending")
-			r, err := nostr.RelayConnect(ctx, u)
-			if err == nil {
-				err = r.Publish(ctx, event)
-				r.Close()
-				if err == nil {
-					tracker.UpdateStatusWithReason(u, "success", "published")
-				} else {
-					tracker.UpdateStatusWithReason(u, "error", err.Error())
+			r, err := s.ConnectRelay(ctx, u)
+			if err != nil {
+				tracker.UpdateStatusWithReason(u, "error", err.Error())
+				return
+			}
+
+			events, err := s.querySyncWithAuth(ctx, r, u, filter, tracker)
+			if err != nil {
+				tracker.UpdateStatusWithReason(u, "error", err.Error())
+				return
+			}
+
+			mu.Lock()
+			for _, ev := range events {
+				if !seen[ev.ID] {
+					seen[ev.ID] = true
+					all = append(all, ev)
 				}
-			} else {
+			}
+			mu.Unlock()
+			switch len(events) {
+			case 0:
+				tracker.UpdateStatusWithReason(u, "success", "no events")
+			case 1:
+				tracker.UpdateStatusWithReason(u, "success", "1 event retrieved")
+			default:
+				tracker.UpdateStatusWithReason(u, "success", fmt.Sprintf("%d events retrieved", len(events)))
+			}
+		}(url)
+	}
+	wg.Wait()
+
+	if verbose {
+		tracker.FinalizeStatus()
+		tracker.DisplayStatus()
+		fmt.Printf("Total time: %dms\n", tracker.GetTotalDuration().Milliseconds())
+	}
+
+	return all, nil
+}
+
+// relayErrors holds the failure of each relay an event couldn't be published to
+type relayErrors []error
+
+func (e relayErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (e relayErrors) Unwrap() []error {
+	return e
+}
+
+// querySyncWithAuth reads every stored event matching a filter up to EOSE, authenticating if asked
+func (s *Settings) querySyncWithAuth(ctx context.Context, r *nostr.Relay, url string, filter nostr.Filter, tracker *StatusTracker) ([]*nostr.Event, error) {
+	sub, err := r.Subscribe(ctx, []nostr.Filter{filter})
+	if err != nil {
+		return nil, err
+	}
+	defer sub.Unsub()
+
+	var events []*nostr.Event
+	for {
+		select {
+		case ev, ok := <-sub.Events:
+			if !ok {
+				return events, nil
+			}
+			events = append(events, ev)
+		case <-sub.EndOfStoredEvents:
+			return events, nil
+		case reason := <-sub.ClosedReason:
+			sub, err = s.ResubscribeAfterClosed(ctx, r, url, sub, reason, tracker)
+			if err != nil {
+				return nil, err
+			}
+			defer sub.Unsub()
+		case <-ctx.Done():
+			return events, ctx.Err()
+		}
+	}
+}
+
+// PublishEvent is a wrapper around CurrentSettings().PublishEvent
+func PublishEvent(ctx context.Context, relays []string, event nostr.Event, verbose bool) error {
+	return CurrentSettings().PublishEvent(ctx, relays, event, verbose)
+}
+
+// PublishEvent publishes an event to the given relays
+// Relays whose NIP-11 limits rule out the event are skipped; unless at least one relay
+// accepts it, the error wraps ErrNoRelay and each relay's failure
+func (s *Settings) PublishEvent(ctx context.Context, relays []string, event nostr.Event, verbose bool) error {
+	var wg sync.WaitGroup
+	var resultMu sync.Mutex
+	var failures relayErrors
+	accepted := 0
+
+	tracker := s.newStatusTracker(verbose)
+	fail := func(url string, err error) {
+		resultMu.Lock()
+		defer resultMu.Unlock()
+		failures = append(failures, fmt.Errorf("%s: %w", url, err))
+	}
+
+	for _, url := range relays {
+		tracker.AddRelay(url)
+		wg.Add(1)
+		go func(u string) {
+			defer wg.Done()
+			tracker.UpdateStatus(u, "pending")
+
+			// Relays without a usable NIP-11 document are tried anyway
+			if info, _ := s.GetRelayInfo(ctx, u); info != nil {
+				if err := s.CheckEventLimits(u, info, event); err != nil {
+					tracker.UpdateStatusWithReason(u, "skipped", err.Error())
+					fail(u, err)
+					return
+				}
+			}
+
+			r, err := s.ConnectRelay(ctx, u)
+			if err == nil {
+				err = s.PublishWithAuth(ctx, r, u, event, tracker)
+			}
+			if err != nil {
 				tracker.UpdateStatusWithReason(u, "error", err.Error())
+				fail(u, err)
+				// Retried in the background, but the event only counts as published once a relay has it
+				if s.outbox != nil {
+					s.outbox.Add(u, event, err)
+				}
+				return
 			}
+			tracker.UpdateStatusWithReason(u, "success", "published")
+			resultMu.Lock()
+			accepted++
+			resultMu.Unlock()
 		}(url)
 	}
 	wg.Wait()
@@ -156,5 +582,15 @@ func PublishEvent(ctx context.Context, event nostr.Event, verbose bool) error {
 		fmt.Printf("Total time: %dms\n", tracker.GetTotalDuration().Milliseconds())
 	}
 
+	if len(relays) == 0 {
+		return fmt.Errorf("%w (no relays configured)", ErrNoRelay)
+	}
+	if accepted == 0 {
+		return fmt.Errorf("%w (%w)", ErrNoRelay, failures)
+	}
+
+	if s.store != nil {
+		s.store.Observe(event)
+	}
 	return nil
 }
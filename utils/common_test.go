@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"pulse/internal/relaytest"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestFetchHistoryPagesControls(t *testing.T) {
+	// Pages of three put the deletion of the older message well past the first one
+	relay := relaytest.New(t)
+	relay.MaxLimit = 3
+	useRelays(t, relay.URL)
+
+	const id = "control-paging-test"
+	key := DeriveKey(id)
+	tag := hex.EncodeToString(key)
+	sk := nostr.GeneratePrivateKey()
+	now := nostr.Now()
+
+	var messages []*nostr.Event
+	for i, body := range []string{"retracted", "kept"} {
+		ev, err := NewMessageEvent(context.Background(), id, key, body, sk, SendOptions{}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ev.CreatedAt = now - 20 + nostr.Timestamp(i)
+		if err := ev.Sign(sk); err != nil {
+			t.Fatal(err)
+		}
+		relay.Publish(&ev)
+		messages = append(messages, &ev)
+	}
+
+	relay.Publish(signedEvent(t, sk, nostr.KindDeletion, now-10, nostr.Tags{{"e", messages[0].ID}, {"t", tag}}))
+	for i := range 5 {
+		unrelated := nostr.GeneratePrivateKey()
+		relay.Publish(signedEvent(t, unrelated, nostr.KindDeletion, now-nostr.Timestamp(5-i), nostr.Tags{{"e", unrelated}, {"t", tag}}))
+	}
+
+	history, err := FetchHistory(context.Background(), id, key, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history.Messages) != 1 || history.Messages[0].ID != messages[1].ID {
+		var bodies []string
+		for _, ev := range history.Messages {
+			if env, err := OpenMessage(ev, key); err == nil {
+				bodies = append(bodies, env.Body)
+			}
+		}
+		t.Fatalf("history = %q, want only the kept message", bodies)
+	}
+}
+
+// deadRelay returns the URL of a relay that refuses connections
+func deadRelay(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "ws://" + listener.Addr().String()
+	listener.Close()
+	return url
+}
+
+func TestPublishEventNeedsARelay(t *testing.T) {
+	relay := relaytest.New(t)
+	dead := deadRelay(t)
+	ev := signedEvent(t, nostr.GeneratePrivateKey(), 4242, nostr.Now(), nil)
+
+	err := CurrentSettings().PublishEvent(context.Background(), []string{dead}, *ev, false)
+	if !errors.Is(err, ErrNoRelay) || !strings.Contains(err.Error(), dead) {
+		t.Errorf("publishing to a dead relay: err = %v, want ErrNoRelay naming the relay", err)
+	}
+	if err := CurrentSettings().PublishEvent(context.Background(), nil, *ev, false); !errors.Is(err, ErrNoRelay) {
+		t.Errorf("publishing to no relays: err = %v, want ErrNoRelay", err)
+	}
+	if err := CurrentSettings().PublishEvent(context.Background(), []string{dead, relay.URL}, *ev, false); err != nil {
+		t.Errorf("publishing with one relay up: %v", err)
+	}
+}
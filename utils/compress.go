@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"fmt"
+	"io"
+)
+
+const (
+	CompressionNone    = "none"
+	CompressionDeflate = "deflate"
+)
+
+var Compression = CompressionNone // applied to message bodies when it makes them smaller; older readers can't open compressed ones
+var MaxMessageSize = 1 << 20      // decompressed size limit, guarding against decompression bombs
+
+// ParseCompression validates a compression setting from the config
+func ParseCompression(value string) (string, error) {
+	switch value {
+	case CompressionNone, CompressionDeflate:
+		return value, nil
+	}
+	return "", fmt.Errorf("unknown compression %q, use deflate or none", value)
+}
+
+// compressBody deflates a message body and encodes it for the JSON envelope
+func compressBody(body string) (string, error) {
+	var buf bytes.Buffer
+	zw, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	zw.Write([]byte(body))
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// decompressBody reverses compressBody, refusing to inflate past limit bytes
+func decompressBody(body string, algorithm string, limit int) (string, error) {
+	if algorithm != CompressionDeflate {
+		return "", fmt.Errorf("unsupported compression %q", algorithm)
+	}
+	data, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		return "", err
+	}
+	zr := flate.NewReader(bytes.NewReader(data))
+	defer zr.Close()
+	plain, err := io.ReadAll(io.LimitReader(zr, int64(limit)+1))
+	if err != nil {
+		return "", err
+	}
+	if len(plain) > limit {
+		return "", fmt.Errorf("decompressed message exceeds %d bytes", limit)
+	}
+	return string(plain), nil
+}
@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestCompressOnlyWhenSmaller(t *testing.T) {
+	key := DeriveKey("compress-test")
+	s := DefaultSettings()
+	s.Compression = CompressionDeflate
+
+	tests := []struct {
+		name       string
+		body       string
+		compressed bool
+	}{
+		{"short body", "hi", false},
+		{"random-looking body", "q8Zr1vXk", false},
+		{"repetitive body", strings.Repeat("pulse status ok\n", 200), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			content, err := s.SealMessage(&Envelope{Body: test.body}, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			plaintext, err := Decrypt(content, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var env Envelope
+			compressed := json.Unmarshal([]byte(plaintext), &env) == nil && env.Compression == CompressionDeflate
+			if compressed != test.compressed {
+				t.Errorf("compressed = %v, want %v (plaintext %d bytes for a %d-byte body)", compressed, test.compressed, len(plaintext), len(test.body))
+			}
+			if compressed && len(plaintext) >= len(test.body) {
+				t.Errorf("compressed plaintext is %d bytes, not smaller than the %d-byte body", len(plaintext), len(test.body))
+			}
+			if !compressed && plaintext != test.body {
+				t.Errorf("uncompressed plaintext = %q, want the bare body", plaintext)
+			}
+		})
+	}
+}
+
+func TestDecompressionLimit(t *testing.T) {
+	const limit = 1000
+	body, err := compressBody(strings.Repeat("0", limit))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain, err := decompressBody(body, CompressionDeflate, limit); err != nil || len(plain) != limit {
+		t.Errorf("body of exactly the limit: %d bytes, err = %v", len(plain), err)
+	}
+	if _, err := decompressBody(body, CompressionDeflate, limit-1); err == nil {
+		t.Error("body one byte over the limit was inflated")
+	}
+}
+
+func TestDecompressionBombRefused(t *testing.T) {
+	// A few kilobytes on the wire that inflate to well past MaxMessageSize
+	key := DeriveKey("bomb-test")
+	bomb := strings.Repeat("\x00", 4<<20)
+	sender := DefaultSettings()
+	sender.Compression = CompressionDeflate
+	content, err := sender.SealMessage(&Envelope{Body: bomb}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(content) > 64<<10 {
+		t.Fatalf("sealed bomb is %d bytes, expected it to compress well", len(content))
+	}
+
+	reader := DefaultSettings()
+	reader.MaxMessageSize = 1 << 20
+	if _, err := reader.OpenMessage(&nostr.Event{Content: content}, key); err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("opening a decompression bomb: err = %v, want the size limit", err)
+	}
+
+	reader.MaxMessageSize = len(bomb)
+	if env, err := reader.OpenMessage(&nostr.Event{Content: content}, key); err != nil || len(env.Body) != len(bomb) {
+		t.Errorf("opening within a raised limit: err = %v", err)
+	}
+}
@@ -7,15 +7,34 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
 )
 
 // Config represents the application configuration
 type Config struct {
 	Relays          []string
+	ReadRelays      []string
+	WriteRelays     []string
 	HistoryLimit    int
 	UserSecret      string
 	DefaultUsername string
 	ListenTimeout   int
+	AuthKey         string
+	IdentityKey     string
+	Proxy           string
+	RelaySettings   map[string]*RelayConfig
+	ChannelSettings map[string]*ChannelConfig
+	EventKind       int
+	EphemeralKind   int
+	LatestKind      int
+	ReadLegacyKind  bool
+	Pow             int
+	MaxPow          int
+	Compression     string
+	UseDaemon       bool
+	HTTPToken       string
+	WebhookSecret   string
 }
 
 // GetConfigPath returns the path to the pulse.conf file
@@ -27,6 +46,19 @@ func GetConfigPath() (string, error) {
 	return filepath.Join(filepath.Dir(exe), "pulse.conf"), nil
 }
 
+// GetDataDir returns the directory used for caches and state files, creating it if needed
+func GetDataDir() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(filepath.Dir(exe), "pulse-data")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
 // LoadConfig loads configuration from pulse.conf if it exists
 func LoadConfig() *Config {
 	confPath, err := GetConfigPath()
@@ -46,12 +78,21 @@ func LoadConfig() *Config {
 	defer file.Close()
 
 	config := &Config{
-		Relays:        []string{},
-		HistoryLimit:  HistoryLimit,
-		UserSecret:    UserSecret,
-		ListenTimeout: ListenTimeout,
+		Relays:          []string{},
+		HistoryLimit:    HistoryLimit,
+		UserSecret:      UserSecret,
+		ListenTimeout:   ListenTimeout,
+		Pow:             PowDifficulty,
+		MaxPow:          MaxPowDifficulty,
+		UseDaemon:       UseDaemon,
+		RelaySettings:   map[string]*RelayConfig{},
+		ChannelSettings: map[string]*ChannelConfig{},
 	}
 
+	// Settings after a [relay <url>] or [channel <id>] header apply to that relay or channel only
+	var currentRelay *RelayConfig
+	var currentChannel *ChannelConfig
+
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
@@ -61,6 +102,27 @@ func LoadConfig() *Config {
 			continue
 		}
 
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			currentRelay = nil
+			currentChannel = nil
+			section := strings.Fields(strings.Trim(line, "[]"))
+			if len(section) == 2 && section[0] == "relay" {
+				url := nostr.NormalizeURL(section[1])
+				if config.RelaySettings[url] == nil {
+					config.RelaySettings[url] = &RelayConfig{}
+				}
+				currentRelay = config.RelaySettings[url]
+			}
+			if len(section) == 2 && section[0] == "channel" {
+				id := section[1]
+				if config.ChannelSettings[id] == nil {
+					config.ChannelSettings[id] = &ChannelConfig{}
+				}
+				currentChannel = config.ChannelSettings[id]
+			}
+			continue
+		}
+
 		parts := strings.SplitN(line, "=", 2)
 		if len(parts) != 2 {
 			continue
@@ -69,16 +131,62 @@ func LoadConfig() *Config {
 		key := strings.TrimSpace(parts[0])
 		value := strings.TrimSpace(parts[1])
 
+		if currentRelay != nil {
+			switch key {
+			case "auth-key":
+				if sk, err := ParseSecretKey(value); err == nil {
+					currentRelay.AuthKey = sk
+				} else {
+					fmt.Fprintf(os.Stderr, "pulse.conf: auth-key: %v\n", err)
+				}
+			case "proxy":
+				if proxy, err := ParseProxy(value); err == nil {
+					currentRelay.Proxy = proxy
+				} else {
+					fmt.Fprintf(os.Stderr, "pulse.conf: proxy: %v\n", err)
+				}
+			case "pow":
+				if difficulty, err := ParsePowDifficulty(value); err == nil {
+					currentRelay.Pow = difficulty
+				} else {
+					fmt.Fprintf(os.Stderr, "pulse.conf: pow: %v\n", err)
+				}
+			}
+			continue
+		}
+
+		if currentChannel != nil {
+			switch key {
+			case "relays":
+				currentChannel.Relays = ParseRelayList(value)
+			case "read-relays":
+				currentChannel.ReadRelays = ParseRelayList(value)
+			case "write-relays":
+				currentChannel.WriteRelays = ParseRelayList(value)
+			case "event-kind":
+				if kind, err := ParseEventKind(value); err == nil {
+					currentChannel.Kind = kind
+				} else {
+					fmt.Fprintf(os.Stderr, "pulse.conf: event-kind: %v\n", err)
+				}
+			case "read-legacy":
+				currentChannel.ReadLegacy = value == "true"
+			case "ephemeral":
+				currentChannel.Ephemeral = value == "true"
+			case "latest":
+				currentChannel.Latest = value == "true"
+			}
+			continue
+		}
+
 		switch key {
 		case "relays":
 			// Parse comma-separated relay list
-			relayList := strings.Split(value, ",")
-			for _, relay := range relayList {
-				relay = strings.TrimSpace(relay)
-				if relay != "" {
-					config.Relays = append(config.Relays, relay)
-				}
-			}
+			config.Relays = append(config.Relays, ParseRelayList(value)...)
+		case "read-relays":
+			config.ReadRelays = ParseRelayList(value)
+		case "write-relays":
+			config.WriteRelays = ParseRelayList(value)
 		case "history-limit":
 			if limit, err := strconv.Atoi(value); err == nil {
 				config.HistoryLimit = limit
@@ -91,6 +199,68 @@ func LoadConfig() *Config {
 			if timeout, err := strconv.Atoi(value); err == nil {
 				config.ListenTimeout = timeout
 			}
+		case "auth-key":
+			if sk, err := ParseSecretKey(value); err == nil {
+				config.AuthKey = sk
+			} else {
+				fmt.Fprintf(os.Stderr, "pulse.conf: auth-key: %v\n", err)
+			}
+		case "identity-key":
+			if sk, err := ParseSecretKey(value); err == nil {
+				config.IdentityKey = sk
+			} else {
+				fmt.Fprintf(os.Stderr, "pulse.conf: identity-key: %v\n", err)
+			}
+		case "event-kind":
+			if kind, err := ParseEventKind(value); err == nil {
+				config.EventKind = kind
+			} else {
+				fmt.Fprintf(os.Stderr, "pulse.conf: event-kind: %v\n", err)
+			}
+		case "ephemeral-kind":
+			if kind, err := ParseEphemeralKind(value); err == nil {
+				config.EphemeralKind = kind
+			} else {
+				fmt.Fprintf(os.Stderr, "pulse.conf: ephemeral-kind: %v\n", err)
+			}
+		case "latest-kind":
+			if kind, err := ParseLatestKind(value); err == nil {
+				config.LatestKind = kind
+			} else {
+				fmt.Fprintf(os.Stderr, "pulse.conf: latest-kind: %v\n", err)
+			}
+		case "read-legacy":
+			config.ReadLegacyKind = value == "true"
+		case "pow":
+			if difficulty, err := ParsePowDifficulty(value); err == nil {
+				config.Pow = difficulty
+			} else {
+				fmt.Fprintf(os.Stderr, "pulse.conf: pow: %v\n", err)
+			}
+		case "compression":
+			if compression, err := ParseCompression(value); err == nil {
+				config.Compression = compression
+			} else {
+				fmt.Fprintf(os.Stderr, "pulse.conf: compression: %v\n", err)
+			}
+		case "max-pow":
+			if difficulty, err := ParsePowDifficulty(value); err == nil {
+				config.MaxPow = difficulty
+			} else {
+				fmt.Fprintf(os.Stderr, "pulse.conf: max-pow: %v\n", err)
+			}
+		case "use-daemon":
+			config.UseDaemon = value == "true"
+		case "http-token":
+			config.HTTPToken = value
+		case "webhook-secret":
+			config.WebhookSecret = value
+		case "proxy":
+			if proxy, err := ParseProxy(value); err == nil && proxy != ProxyDirect {
+				config.Proxy = proxy
+			} else if err != nil {
+				fmt.Fprintf(os.Stderr, "pulse.conf: proxy: %v\n", err)
+			}
 		}
 	}
 
@@ -106,6 +276,15 @@ func ApplyConfig(config *Config) {
 	if len(config.Relays) > 0 {
 		Relays = config.Relays
 	}
+	if len(config.ReadRelays) > 0 {
+		ReadRelays = config.ReadRelays
+	}
+	if len(config.WriteRelays) > 0 {
+		WriteRelays = config.WriteRelays
+	}
+	if len(config.ChannelSettings) > 0 {
+		ChannelSettings = config.ChannelSettings
+	}
 	if config.HistoryLimit > 0 {
 		HistoryLimit = config.HistoryLimit
 	}
@@ -115,6 +294,40 @@ func ApplyConfig(config *Config) {
 	if config.ListenTimeout >= 0 {
 		ListenTimeout = config.ListenTimeout
 	}
+	if config.AuthKey != "" {
+		AuthKey = config.AuthKey
+	}
+	if config.IdentityKey != "" {
+		IdentityKey = config.IdentityKey
+	}
+	if len(config.RelaySettings) > 0 {
+		RelaySettings = config.RelaySettings
+	}
+	if config.Proxy != "" {
+		Proxy = config.Proxy
+	}
+	if config.EventKind != 0 {
+		EventKind = config.EventKind
+	}
+	if config.EphemeralKind != 0 {
+		EphemeralKind = config.EphemeralKind
+	}
+	if config.LatestKind != 0 {
+		LatestKind = config.LatestKind
+	}
+	ReadLegacyKind = config.ReadLegacyKind
+	PowDifficulty = config.Pow
+	MaxPowDifficulty = config.MaxPow
+	if config.Compression != "" {
+		Compression = config.Compression
+	}
+	UseDaemon = config.UseDaemon
+	if config.HTTPToken != "" {
+		HTTPToken = config.HTTPToken
+	}
+	if config.WebhookSecret != "" {
+		WebhookSecret = config.WebhookSecret
+	}
 }
 
 // GenerateConfig creates a pulse.conf file with default settings
@@ -135,6 +348,10 @@ func GenerateConfig() error {
 # List of Nostr relays (comma-separated)
 relays = wss://relay.damus.io, wss://nos.lol, wss://relay.snort.social
 
+# Separate relay sets for subscribing and publishing (optional, default to relays)
+# read-relays = wss://my-private-relay.example.com
+# write-relays = wss://relay.damus.io, wss://nos.lol, wss://relay.snort.social
+
 # Maximum number of messages to retrieve from history
 history-limit = 5
 
@@ -147,6 +364,62 @@ user-secret = super-secret-key
 
 # Listen timeout in seconds (for -l flag, 0 = no timeout)
 listen-timeout = 30
+
+# Event kind for Pulse messages (an application-specific regular kind)
+event-kind = 4242
+
+# Event kind for ephemeral messages, which relays forward but never store (20000-29999)
+ephemeral-kind = 21242
+
+# Event kind for latest-value channels, where relays keep only the newest value (30000-39999)
+latest-kind = 34242
+
+# Also read kind-1 notes written by older Pulse versions (migration mode)
+# read-legacy = true
+
+# Compress message bodies before encryption when it makes them smaller (deflate or none)
+# Pulse versions without compression support can't read compressed messages
+# compression = deflate
+
+# Send, get and listen through "pulse daemon" when it is running (true or false)
+use-daemon = true
+
+# Bearer token required by "pulse serve-http" (optional, a random one is generated at startup)
+# http-token = change-me
+
+# HMAC key "pulse forward" signs webhook requests with (optional)
+# webhook-secret = change-me
+
+# Persistent identity key (hex or nsec) that signs your messages (optional)
+# Needed to delete your own messages later; without it each message uses a throwaway key
+# identity-key = nsec1...
+
+# Identity key (hex or nsec) used to answer NIP-42 AUTH challenges (optional, defaults to identity-key)
+# auth-key = nsec1...
+
+# Proxy for all relay connections, e.g. Tor (optional, needed for .onion relays)
+# proxy = socks5://127.0.0.1:9050
+
+# NIP-13 proof-of-work difficulty for every published event (optional)
+# Relays advertising min_pow_difficulty in NIP-11 get it automatically, up to max-pow
+# pow = 16
+max-pow = 28
+
+# Per-relay settings go in a [relay <url>] section and override the globals above
+# Use proxy = direct to bypass the global proxy for one relay
+# [relay wss://private.example.com]
+# auth-key = nsec1...
+# proxy = direct
+# pow = 20
+
+# Per-channel relay sets go in a [channel <id>] section
+# [channel team-channel]
+# read-relays = wss://my-private-relay.example.com
+# write-relays = wss://relay.damus.io, wss://nos.lol
+# event-kind = 4242
+# read-legacy = true
+# ephemeral = true
+# latest = true
 `
 
 	file, err := os.Create(confPath)
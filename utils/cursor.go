@@ -0,0 +1,201 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+var cursorNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+var cursorPageSize = 200 // events requested per backlog query
+
+// Cursor records the last message a listener processed, so the next run resumes after it
+type Cursor struct {
+	Name      string   `json:"-"`
+	CreatedAt int64    `json:"created_at"`
+	ID        string   `json:"id"`
+	Seen      []string `json:"seen"` // every processed ID with timestamp CreatedAt
+}
+
+// LoadCursor reads a named cursor from the data directory; a new cursor has CreatedAt 0
+func LoadCursor(name string) (*Cursor, error) {
+	if !cursorNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid cursor name %q, use letters, digits, '.', '_' and '-'", name)
+	}
+	cursor := &Cursor{Name: name}
+
+	path, err := cursorPath(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cursor, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, cursor); err != nil {
+		return nil, fmt.Errorf("cursor %s: %w", name, err)
+	}
+	return cursor, nil
+}
+
+// Processed reports whether a message is at or before the cursor
+func (c *Cursor) Processed(msg *Message) bool {
+	if msg.CreatedAt != c.CreatedAt {
+		return msg.CreatedAt < c.CreatedAt
+	}
+	for _, id := range c.Seen {
+		if id == msg.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// Advance moves the cursor past a message and saves it
+func (c *Cursor) Advance(msg *Message) error {
+	if msg.CreatedAt > c.CreatedAt {
+		c.CreatedAt = msg.CreatedAt
+		c.Seen = nil
+	}
+	c.ID = msg.ID
+	c.Seen = append(c.Seen, msg.ID)
+
+	path, err := cursorPath(c.Name)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0600)
+}
+
+func cursorPath(name string) (string, error) {
+	dir, err := GetDataDir()
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, "cursors")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name+".json"), nil
+}
+
+// SubscribeFromCursor delivers the messages stored on the channels since the cursor, oldest first,
+// then live ones. The live subscription starts before the backlog is read, so nothing falls in between
+func SubscribeFromCursor(ctx context.Context, ids []string, cursor *Cursor, verbose bool) <-chan *Message {
+	live := SubscribeChannels(ctx, ids, verbose)
+	out := make(chan *Message)
+
+	go func() {
+		defer drainAndClose(live, out)
+
+		delivered := make(map[string]bool)
+		if cursor.CreatedAt > 0 {
+			var backlog []*Message
+			for _, id := range ids {
+				messages, err := fetchBacklog(ctx, id, nostr.Timestamp(cursor.CreatedAt), verbose)
+				if err != nil && verbose {
+					fmt.Fprintf(os.Stderr, "backlog %s: %v\n", id, err)
+				}
+				backlog = append(backlog, messages...)
+			}
+			sortMessages(backlog)
+
+			for _, msg := range backlog {
+				delivered[msg.ID] = true
+				select {
+				case out <- msg:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+
+		for msg := range live {
+			if delivered[msg.ID] {
+				continue
+			}
+			select {
+			case out <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// fetchBacklog reads every stored message since a timestamp, paging back from the newest
+// Each relay is paged on its own, since relays cap pages at their own max_limit
+func fetchBacklog(ctx context.Context, id string, since nostr.Timestamp, verbose bool) ([]*Message, error) {
+	key := DeriveKey(id)
+	filter := MessageFilter(id, key)
+	filter.Since = &since
+	filter.Limit = cursorPageSize
+
+	tracker := NewStatusTracker(verbose)
+	seen := make(map[string]bool)
+	var messages []*Message
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, url := range RelaysForReading(id) {
+		tracker.AddRelay(url)
+		wg.Add(1)
+		go func(u string) {
+			defer wg.Done()
+			tracker.UpdateStatus(u, "pending")
+			r, err := ConnectRelay(ctx, u)
+			if err != nil {
+				tracker.UpdateStatusWithReason(u, "error", err.Error())
+				return
+			}
+			// Its own seen set, so events another relay already returned don't end its paging
+			events := CurrentSettings().pageBack(ctx, r, u, filter, 0, make(map[string]bool), tracker)
+			tracker.UpdateStatusWithReason(u, "success", fmt.Sprintf("%d events retrieved", len(events)))
+
+			mu.Lock()
+			defer mu.Unlock()
+			for _, ev := range events {
+				if seen[ev.ID] {
+					continue
+				}
+				seen[ev.ID] = true
+				if env, err := OpenMessage(ev, key); err == nil {
+					messages = append(messages, newMessage(id, ev, env))
+				}
+			}
+		}(url)
+	}
+	wg.Wait()
+
+	if verbose {
+		tracker.FinalizeStatus()
+		tracker.DisplayStatus()
+	}
+	return messages, ctx.Err()
+}
+
+// sortMessages orders messages oldest first, by ID within the same second
+func sortMessages(messages []*Message) {
+	sort.Slice(messages, func(i, j int) bool {
+		if messages[i].CreatedAt != messages[j].CreatedAt {
+			return messages[i].CreatedAt < messages[j].CreatedAt
+		}
+		return messages[i].ID < messages[j].ID
+	})
+}
@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"pulse/internal/relaytest"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// storeMessages signs count messages on a channel a second apart, ending now, and stores
+// them on the relays
+func storeMessages(t *testing.T, id string, count int, relays ...*relaytest.Relay) []string {
+	t.Helper()
+	sk := nostr.GeneratePrivateKey()
+	var bodies []string
+	for i := range count {
+		body := fmt.Sprintf("message %d", i)
+		ev, err := NewMessageEvent(context.Background(), id, DeriveKey(id), body, sk, SendOptions{}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ev.CreatedAt = nostr.Now() - nostr.Timestamp(count-i)
+		if err := ev.Sign(sk); err != nil {
+			t.Fatal(err)
+		}
+		for _, relay := range relays {
+			relay.Publish(&ev)
+		}
+		bodies = append(bodies, body)
+	}
+	return bodies
+}
+
+func TestFetchBacklogPagesEachRelay(t *testing.T) {
+	// One relay caps pages far below cursorPageSize, the other only holds the newest messages
+	capped, recent := relaytest.New(t), relaytest.New(t)
+	capped.MaxLimit = 3
+	useRelays(t, capped.URL, recent.URL)
+
+	bodies := storeMessages(t, "backlog-test", 10, capped)
+	storeMessages(t, "backlog-test", 2, recent)
+
+	since := nostr.Now() - 60
+	messages, err := fetchBacklog(context.Background(), "backlog-test", since, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]bool)
+	for _, msg := range messages {
+		got[msg.Body] = true
+	}
+	for _, body := range bodies {
+		if !got[body] {
+			t.Errorf("backlog is missing %q from the capped relay", body)
+		}
+	}
+	if len(messages) != 12 {
+		t.Errorf("backlog has %d messages, want 12", len(messages))
+	}
+}
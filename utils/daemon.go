@@ -0,0 +1,380 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var UseDaemon = true // send, get and listen go through a running daemon instead of connecting themselves
+
+// JSON-RPC 2.0 error codes; the -320xx range is for Pulse's own failures
+const (
+	daemonParseError     = -32700
+	daemonInvalidParams  = -32602
+	daemonMethodNotFound = -32601
+	daemonEncryptError   = -32000 // the message could not be sealed
+	daemonPublishError   = -32001 // no relay took the message
+	daemonReadError      = -32002 // the message could not be read
+	daemonAlreadyRead    = -32003 // the burn-after-read message was already consumed
+)
+
+// daemonRequest is a JSON-RPC 2.0 request, one per line on the socket
+type daemonRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// daemonResponse is a JSON-RPC 2.0 response, or a notification when Method is set
+type daemonResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  any             `json:"params,omitempty"`
+	Result  any             `json:"result,omitempty"`
+	Error   *daemonError    `json:"error,omitempty"`
+}
+
+type daemonError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *daemonError) Error() string {
+	return e.Message
+}
+
+// Unwrap lets callers of the daemon test its failures as they would their own
+func (e *daemonError) Unwrap() error {
+	switch e.Code {
+	case daemonPublishError:
+		return ErrNoRelay
+	case daemonAlreadyRead:
+		return ErrAlreadyRead
+	}
+	return nil
+}
+
+type daemonSendParams struct {
+	Channel   string `json:"channel"`
+	Message   string `json:"message"`
+	Ephemeral bool   `json:"ephemeral,omitempty"`
+	Latest    bool   `json:"latest,omitempty"`
+	Once      bool   `json:"once,omitempty"`
+	TTL       string `json:"ttl,omitempty"` // Go duration, e.g. "10m"
+}
+
+// options converts the send parameters into SendOptions
+func (p daemonSendParams) options() (SendOptions, error) {
+	opts := SendOptions{Ephemeral: p.Ephemeral, Latest: p.Latest, Once: p.Once}
+	if p.TTL != "" {
+		ttl, err := time.ParseDuration(p.TTL)
+		if err != nil || ttl < 0 {
+			return opts, fmt.Errorf("invalid ttl %q", p.TTL)
+		}
+		opts.TTL = ttl
+	}
+	return opts, nil
+}
+
+type daemonSendResult struct {
+	EventID string `json:"event_id"`
+}
+
+type daemonGetParams struct {
+	Channel string `json:"channel"`
+	Latest  bool   `json:"latest,omitempty"`
+}
+
+type daemonGetResult struct {
+	Body      string `json:"body"`
+	EventID   string `json:"event_id,omitempty"`
+	BurnError string `json:"burn_error,omitempty"` // set when a burn-after-read message was shown but not burned
+}
+
+type daemonSubscribeParams struct {
+	Channels []string `json:"channels"`
+}
+
+type daemonStatusResult struct {
+	PID      int      `json:"pid"`
+	Uptime   int64    `json:"uptime"` // seconds
+	Relays   []string `json:"relays"` // relays with an open connection
+	Channels []string `json:"channels"`
+	Outbox   int      `json:"outbox"` // relay deliveries waiting for a retry
+}
+
+// DaemonSocketPath returns the Unix socket the daemon listens on
+func DaemonSocketPath() (string, error) {
+	dir, err := GetDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "pulse.sock"), nil
+}
+
+// daemon serves the socket API on top of the pooled relay connections
+type daemon struct {
+	verbose bool
+	started time.Time
+
+	reads ChannelLocks
+}
+
+// RunDaemon keeps relay connections, channel history and an outbox of failed publishes in memory,
+// serving send, get and subscribe over a Unix socket until interrupted
+func RunDaemon(verbose bool) error {
+	listener, err := listenDaemon()
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	enableServerMode(ctx, verbose)
+	d := &daemon{verbose: verbose, started: time.Now()}
+
+	fmt.Printf("Pulse daemon listening on %s\n", listener.Addr())
+	return d.serve(ctx, listener)
+}
+
+// listenDaemon opens the daemon socket, refusing if another daemon is already on it
+func listenDaemon() (net.Listener, error) {
+	path, err := DaemonSocketPath()
+	if err != nil {
+		return nil, err
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("daemon already running on %s", path)
+	}
+	// Left behind by a daemon that didn't shut down cleanly
+	os.Remove(path)
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// serve accepts connections until the context ends, then waits for them to finish
+func (d *daemon) serve(ctx context.Context, listener net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.serveConn(ctx, conn)
+		}()
+	}
+}
+
+// enableServerMode sets a long-running process up to retry failed publishes and keep channel
+// history in memory until the context ends
+func enableServerMode(ctx context.Context, verbose bool) {
+	// The daemon is what the other commands talk to, so servers must not try to reach it
+	UseDaemon = false
+	enableOutbox(ctx, verbose)
+	enableMessageStore(ctx)
+	go warmUp(ctx, verbose)
+}
+
+// warmUp connects to every relay and caches their NIP-11 documents, so the first send is as
+// fast as the ones after it
+func warmUp(ctx context.Context, verbose bool) {
+	for _, url := range AllRelays() {
+		go func(u string) {
+			connectCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+			defer cancel()
+			GetRelayInfo(connectCtx, u)
+			if _, err := ConnectRelay(connectCtx, u); err != nil && verbose {
+				fmt.Printf("%s: %v\n", u, err)
+			}
+		}(url)
+	}
+}
+
+// serveConn answers the requests on one connection, each in its own goroutine
+// Subscriptions end when the client hangs up, while sends and gets already started run to
+// completion; a subscription that ends on the daemon's side closes the connection
+func (d *daemon) serveConn(ctx context.Context, conn net.Conn) {
+	connCtx, hangUp := context.WithCancel(ctx)
+	defer hangUp()
+	go func() {
+		<-connCtx.Done()
+		conn.Close()
+	}()
+
+	var writeMu sync.Mutex
+	write := func(resp daemonResponse) {
+		resp.JSONRPC = "2.0"
+		data, err := json.Marshal(resp)
+		if err != nil {
+			data, _ = json.Marshal(daemonResponse{JSONRPC: "2.0", ID: resp.ID, Error: &daemonError{Code: daemonReadError, Message: err.Error()}})
+		}
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		conn.Write(append(data, '\n'))
+	}
+
+	var wg sync.WaitGroup
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), 4*MaxMessageSize)
+	for scanner.Scan() {
+		var req daemonRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			write(daemonResponse{Error: &daemonError{Code: daemonParseError, Message: err.Error()}})
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			startTime := time.Now()
+			result, err := d.handle(ctx, connCtx, req, hangUp, write)
+			if d.verbose {
+				status := "ok"
+				if err != nil {
+					status = err.Message
+				}
+				fmt.Printf("%s: %s in %dms\n", req.Method, status, time.Since(startTime).Milliseconds())
+			}
+			if result != nil || err != nil {
+				write(daemonResponse{ID: req.ID, Result: result, Error: err})
+			}
+		}()
+	}
+
+	hangUp()
+	wg.Wait()
+}
+
+// handle runs one request and returns its result
+// Subscriptions answer and stream notifications through write themselves, for as long as connCtx lasts
+func (d *daemon) handle(ctx context.Context, connCtx context.Context, req daemonRequest, hangUp context.CancelFunc, write func(daemonResponse)) (any, *daemonError) {
+	invalid := func(err error) *daemonError {
+		return &daemonError{Code: daemonInvalidParams, Message: err.Error()}
+	}
+
+	switch req.Method {
+	case "send":
+		var params daemonSendParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalid(err)
+		}
+		return d.send(ctx, params)
+
+	case "get":
+		var params daemonGetParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalid(err)
+		}
+		return d.get(ctx, params)
+
+	case "subscribe":
+		var params daemonSubscribeParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalid(err)
+		}
+		if len(params.Channels) == 0 {
+			return nil, invalid(fmt.Errorf("no channels given"))
+		}
+
+		messages := SubscribeChannels(connCtx, params.Channels, false)
+		write(daemonResponse{ID: req.ID, Result: params})
+		for msg := range messages {
+			write(daemonResponse{Method: "message", Params: msg})
+		}
+		hangUp()
+		return nil, nil
+
+	case "status":
+		return d.status(), nil
+	}
+
+	return nil, &daemonError{Code: daemonMethodNotFound, Message: fmt.Sprintf("unknown method %q", req.Method)}
+}
+
+func (d *daemon) send(ctx context.Context, params daemonSendParams) (any, *daemonError) {
+	opts, err := params.options()
+	if err == nil {
+		err = CurrentSettings().checkSendOptions(params.Channel, opts)
+	}
+	if err != nil {
+		return nil, &daemonError{Code: daemonInvalidParams, Message: err.Error()}
+	}
+
+	ev, err := NewMessageEvent(ctx, params.Channel, DeriveKey(params.Channel), params.Message, SigningKey(), opts, false)
+	if err != nil {
+		return nil, &daemonError{Code: daemonEncryptError, Message: err.Error()}
+	}
+	if err := PublishEvent(ctx, opts.writeRelays(params.Channel), ev, false); err != nil {
+		return nil, &daemonError{Code: daemonPublishError, Message: err.Error()}
+	}
+	return daemonSendResult{EventID: ev.ID}, nil
+}
+
+func (d *daemon) get(ctx context.Context, params daemonGetParams) (any, *daemonError) {
+	msg, burnErr, err := CurrentSettings().ReadMessage(ctx, &d.reads, params.Channel, RetrieveOptions{Latest: params.Latest}, false)
+	if errors.Is(err, ErrAlreadyRead) {
+		return nil, &daemonError{Code: daemonAlreadyRead, Message: err.Error()}
+	}
+	if err != nil {
+		return nil, &daemonError{Code: daemonReadError, Message: err.Error()}
+	}
+
+	result := daemonGetResult{Body: strings.TrimRight(msg.Body, "\n"), EventID: msg.ID}
+	if burnErr != nil {
+		result.BurnError = burnErr.Error()
+	}
+	return result, nil
+}
+
+func (d *daemon) status() daemonStatusResult {
+	cliStore.mu.Lock()
+	channels := make([]string, 0, len(cliStore.channels))
+	for id := range cliStore.channels {
+		channels = append(channels, id)
+	}
+	cliStore.mu.Unlock()
+
+	return daemonStatusResult{
+		PID:      os.Getpid(),
+		Uptime:   int64(time.Since(d.started).Seconds()),
+		Relays:   cliRelays.connected(),
+		Channels: channels,
+		Outbox:   cliOutbox.Pending(),
+	}
+}
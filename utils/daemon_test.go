@@ -0,0 +1,184 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"pulse/internal/relaytest"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// startDaemon serves the daemon socket on the configured relays until the test ends
+func startDaemon(t *testing.T) {
+	t.Helper()
+	useDaemon, outbox, store := UseDaemon, cliOutbox, cliStore
+	listener, err := listenDaemon()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	enableServerMode(ctx, false)
+	d := &daemon{started: time.Now()}
+	done := make(chan error, 1)
+	go func() {
+		done <- d.serve(ctx, listener)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("daemon returned %v after cancellation", err)
+		}
+		UseDaemon, cliOutbox, cliStore = useDaemon, outbox, store
+	})
+}
+
+func TestDaemonSocket(t *testing.T) {
+	relay := relaytest.New(t)
+	useRelays(t, relay.URL)
+	output := captureStdout(t)
+	startDaemon(t)
+	const id = "daemon-test"
+
+	eventID, err := SendThroughDaemon(id, "hello", SendOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events := relay.Events(nostr.Filter{IDs: []string{eventID}}); len(events) != 1 {
+		t.Fatalf("sent event is on the relay %d times", len(events))
+	}
+
+	msg, burnErr, err := ReadThroughDaemon(id, RetrieveOptions{})
+	if err != nil || burnErr != nil {
+		t.Fatalf("get: err = %v, burn error = %v", err, burnErr)
+	}
+	if msg.Body != "hello" || msg.ID != eventID {
+		t.Errorf("get returned %q (%s), want hello (%s)", msg.Body, msg.ID, eventID)
+	}
+
+	// The get left the daemon following the channel
+	relaytest.WaitFor(t, "the daemon's history subscription", func() bool {
+		return relay.Subscriptions() == 1
+	})
+	ctx, hangUp := context.WithCancel(context.Background())
+	defer hangUp()
+	messages := subscribeThroughDaemon(ctx, []string{id}, false)
+	if messages == nil {
+		t.Fatal("the daemon refused the subscription")
+	}
+	relaytest.WaitFor(t, "the listener's subscription", func() bool {
+		return relay.Subscriptions() == 2
+	})
+	if _, err := SendThroughDaemon(id, "live", SendOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// The live filter starts at the current second, so the first message may come through too
+	timeout := time.After(5 * time.Second)
+	for received := false; !received; {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				t.Fatal("subscription ended before the live message")
+			}
+			if msg.Channel != id {
+				t.Errorf("message labelled %q, want %q", msg.Channel, id)
+			}
+			received = msg.Body == "live"
+		case <-timeout:
+			t.Fatal("subscription didn't deliver the live message")
+		}
+	}
+	// Hanging up ends the daemon's subscription, while its history one stays
+	hangUp()
+	relaytest.WaitFor(t, "the listener's subscription to close", func() bool {
+		return relay.Subscriptions() == 1
+	})
+
+	if err := PrintDaemonStatus(); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{nostr.NormalizeURL(relay.URL), "Channels: " + id, "Outbox:   0 pending"} {
+		relaytest.WaitFor(t, "status to show "+want, func() bool {
+			return strings.Contains(output(), want)
+		})
+	}
+}
+
+func TestDaemonErrors(t *testing.T) {
+	relay := relaytest.New(t)
+	useRelays(t, relay.URL)
+	captureStdout(t)
+
+	if _, err := SendThroughDaemon("daemon-errors-test", "hello", SendOptions{}); !errors.Is(err, ErrNoDaemon) {
+		t.Fatalf("send with no daemon running: err = %v, want ErrNoDaemon", err)
+	}
+	startDaemon(t)
+
+	tests := []struct {
+		name   string
+		method string
+		params any
+		code   int
+	}{
+		{"unknown method", "publish", struct{}{}, daemonMethodNotFound},
+		{"malformed params", "send", []string{"daemon-errors-test"}, daemonInvalidParams},
+		{"burn-after-read latest value", "send", daemonSendParams{Channel: "daemon-errors-test", Message: "x", Once: true, Latest: true}, daemonInvalidParams},
+		{"bad ttl", "send", daemonSendParams{Channel: "daemon-errors-test", Message: "x", TTL: "soon"}, daemonInvalidParams},
+		{"empty subscription", "subscribe", daemonSubscribeParams{}, daemonInvalidParams},
+		{"nothing to read", "get", daemonGetParams{Channel: "daemon-errors-test"}, daemonReadError},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handled, err := callDaemon(test.method, test.params, nil)
+			var daemonErr *daemonError
+			if !handled || !errors.As(err, &daemonErr) || daemonErr.Code != test.code {
+				t.Errorf("handled = %v, err = %v, want error code %d", handled, err, test.code)
+			}
+		})
+	}
+	if events := relay.Events(nostr.Filter{}); len(events) != 0 {
+		t.Errorf("%d events published by refused requests", len(events))
+	}
+}
+
+func TestOutboxRetriesAfterRelayFailure(t *testing.T) {
+	relay := relaytest.New(t)
+	useRelays(t, relay.URL)
+	captureStdout(t)
+	retries, backoff, outbox := OutboxRetries, OutboxBackoff, cliOutbox
+	OutboxRetries, OutboxBackoff = 10, 20*time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	enableOutbox(ctx, false)
+	t.Cleanup(func() {
+		cancel()
+		OutboxRetries, OutboxBackoff, cliOutbox = retries, backoff, outbox
+	})
+
+	relay.SetDown(true)
+	_, err := CurrentSettings().SendMessage(ctx, "outbox-test", "queued", SendOptions{}, false)
+	if !errors.Is(err, ErrNoRelay) {
+		t.Fatalf("send while the relay is down: err = %v, want ErrNoRelay", err)
+	}
+	if pending := cliOutbox.Pending(); pending != 1 {
+		t.Fatalf("%d deliveries pending, want 1", pending)
+	}
+
+	relay.SetDown(false)
+	relaytest.WaitFor(t, "the retry to reach the relay", func() bool {
+		return len(relay.Events(nostr.Filter{Kinds: []int{KindPulseMessage}})) == 1
+	})
+	relaytest.WaitFor(t, "the outbox to empty", func() bool {
+		return cliOutbox.Pending() == 0
+	})
+
+	// A relay that answered and refused the event is not retried
+	cliOutbox.Add(relay.URL, nostr.Event{ID: "refused"}, fmt.Errorf("msg: blocked: spam"))
+	if pending := cliOutbox.Pending(); pending != 0 {
+		t.Errorf("%d deliveries pending after a refusal, want 0", pending)
+	}
+}
@@ -0,0 +1,191 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+var ErrNoDaemon = errors.New("daemon is not running")
+
+// dialDaemon connects to the running daemon, or returns nil when there is none
+func dialDaemon() net.Conn {
+	path, err := DaemonSocketPath()
+	if err != nil {
+		return nil
+	}
+	conn, err := net.DialTimeout("unix", path, 200*time.Millisecond)
+	if err != nil {
+		return nil
+	}
+	return conn
+}
+
+// newDaemonReader reads the daemon's line-delimited responses
+func newDaemonReader(conn net.Conn) *bufio.Scanner {
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), 4*MaxMessageSize)
+	return scanner
+}
+
+// writeDaemonRequest sends one JSON-RPC request line
+func writeDaemonRequest(conn net.Conn, method string, params any) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(daemonRequest{JSONRPC: "2.0", ID: json.RawMessage("1"), Method: method, Params: data})
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(append(line, '\n'))
+	return err
+}
+
+// readDaemonResponse reads the response to a request, decoding its result
+func readDaemonResponse(scanner *bufio.Scanner, result any) error {
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("daemon: %w", err)
+		}
+		return fmt.Errorf("daemon closed the connection")
+	}
+	var resp struct {
+		Result json.RawMessage `json:"result"`
+		Error  *daemonError    `json:"error"`
+	}
+	if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+		return fmt.Errorf("daemon: %w", err)
+	}
+	if resp.Error != nil {
+		if resp.Error.Code == daemonAlreadyRead {
+			return ErrAlreadyRead
+		}
+		return resp.Error
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(resp.Result, result)
+}
+
+// callDaemon runs one request on the daemon; handled is false when no daemon is running
+func callDaemon(method string, params any, result any) (handled bool, err error) {
+	conn := dialDaemon()
+	if conn == nil {
+		return false, nil
+	}
+	defer conn.Close()
+
+	if err := writeDaemonRequest(conn, method, params); err != nil {
+		return true, fmt.Errorf("daemon: %w", err)
+	}
+	return true, readDaemonResponse(newDaemonReader(conn), result)
+}
+
+// SendThroughDaemon publishes a message through the running daemon and returns its event ID
+// It returns ErrNoDaemon when none is running; publish failures wrap ErrNoRelay
+func SendThroughDaemon(id string, message string, opts SendOptions) (string, error) {
+	params := daemonSendParams{Channel: id, Message: message, Ephemeral: opts.Ephemeral, Latest: opts.Latest, Once: opts.Once}
+	if opts.TTL > 0 {
+		params.TTL = opts.TTL.String()
+	}
+
+	var result daemonSendResult
+	handled, err := callDaemon("send", params, &result)
+	if !handled {
+		return "", ErrNoDaemon
+	}
+	return result.EventID, err
+}
+
+// ReadThroughDaemon reads what get would show through the running daemon, which burns
+// one-time messages; a failed burn is reported separately, as by ReadMessage
+// It returns ErrNoDaemon when none is running
+func ReadThroughDaemon(id string, opts RetrieveOptions) (msg *Message, burnErr error, err error) {
+	var result daemonGetResult
+	handled, err := callDaemon("get", daemonGetParams{Channel: id, Latest: opts.Latest}, &result)
+	if !handled {
+		return nil, nil, ErrNoDaemon
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if result.BurnError != "" {
+		burnErr = errors.New(result.BurnError)
+	}
+	return &Message{ID: result.EventID, Channel: id, Body: result.Body}, burnErr, nil
+}
+
+// subscribeThroughDaemon streams channel messages from the daemon's subscription
+// It returns nil when no daemon is running or it refused, so the caller subscribes itself
+func subscribeThroughDaemon(ctx context.Context, ids []string, verbose bool) <-chan *Message {
+	conn := dialDaemon()
+	if conn == nil {
+		return nil
+	}
+	scanner := newDaemonReader(conn)
+	err := writeDaemonRequest(conn, "subscribe", daemonSubscribeParams{Channels: ids})
+	if err == nil {
+		err = readDaemonResponse(scanner, nil)
+	}
+	if err != nil {
+		conn.Close()
+		fmt.Fprintf(os.Stderr, "daemon: subscribe: %v\n", err)
+		return nil
+	}
+	if verbose {
+		fmt.Printf("Listening through the daemon on %s\n", strings.Join(ids, ", "))
+	}
+
+	out := make(chan *Message)
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	go func() {
+		// Closed once the connection is, which is all a subscription holds on this side
+		defer close(out)
+		for scanner.Scan() {
+			var notification struct {
+				Method string  `json:"method"`
+				Params Message `json:"params"`
+			}
+			if err := json.Unmarshal(scanner.Bytes(), &notification); err != nil || notification.Method != "message" {
+				continue
+			}
+			select {
+			case out <- &notification.Params:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// PrintDaemonStatus shows what the running daemon holds
+func PrintDaemonStatus() error {
+	var status daemonStatusResult
+	handled, err := callDaemon("status", struct{}{}, &status)
+	if !handled {
+		return ErrNoDaemon
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("PID:      %d\n", status.PID)
+	fmt.Printf("Uptime:   %s\n", time.Duration(status.Uptime)*time.Second)
+	fmt.Printf("Relays:   %s\n", strings.Join(status.Relays, ", "))
+	fmt.Printf("Channels: %s\n", strings.Join(status.Channels, ", "))
+	fmt.Printf("Outbox:   %d pending\n", status.Outbox)
+	return nil
+}
@@ -0,0 +1,173 @@
+package utils
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// DeleteEvents publishes a NIP-09 deletion request for specific events signed by our identity
+// channel is optional and only selects which relays to use
+func DeleteEvents(eventIDs []string, channel string, verbose bool) error {
+	startTime := time.Now()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pk, err := identityPublicKey()
+	if err != nil {
+		return err
+	}
+
+	if verbose {
+		fmt.Println("Looking up events...")
+	}
+
+	// The targets are needed for their channel tag, so readers can find the deletion
+	targets, err := QueryRelays(ctx, RelaysForReading(channel), nostr.Filter{IDs: eventIDs}, verbose)
+	if err != nil {
+		return err
+	}
+
+	found := make(map[string]bool)
+	var mine []*nostr.Event
+	for _, ev := range targets {
+		found[ev.ID] = true
+		if ev.PubKey != pk {
+			return fmt.Errorf("event %s was not signed by your identity-key", ev.ID)
+		}
+		mine = append(mine, ev)
+	}
+	for _, id := range eventIDs {
+		if !found[id] {
+			return fmt.Errorf("event %s not found on any relay", id)
+		}
+	}
+
+	return publishDeletion(ctx, channel, mine, verbose, startTime)
+}
+
+// DeleteChannelMessages publishes a NIP-09 deletion request for our messages on a channel
+// sent before the given time (0 means all of them)
+func DeleteChannelMessages(channel string, before nostr.Timestamp, verbose bool) error {
+	startTime := time.Now()
+	key := DeriveKey(channel)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pk, err := identityPublicKey()
+	if err != nil {
+		return err
+	}
+
+	if verbose {
+		fmt.Println("Looking up messages...")
+	}
+
+	filter := MessageFilter(channel, key)
+	filter.Authors = []string{pk}
+	if before != 0 {
+		filter.Until = &before
+	}
+
+	targets, err := QueryRelays(ctx, RelaysForReading(channel), filter, verbose)
+	if err != nil {
+		return err
+	}
+	if len(targets) == 0 {
+		return fmt.Errorf("no messages of yours found on this channel")
+	}
+
+	return publishDeletion(ctx, channel, targets, verbose, startTime)
+}
+
+// publishDeletion signs and publishes a kind-5 event referencing the targets
+func publishDeletion(ctx context.Context, channel string, targets []*nostr.Event, verbose bool, startTime time.Time) error {
+	ev := nostr.Event{
+		CreatedAt: nostr.Now(),
+		Kind:      nostr.KindDeletion,
+		Tags:      nostr.Tags{},
+		Content:   "retracted",
+	}
+
+	kinds := make(map[int]bool)
+	channelTags := make(map[string]bool)
+	for _, target := range targets {
+		ev.Tags = append(ev.Tags, nostr.Tag{"e", target.ID})
+		if !kinds[target.Kind] {
+			kinds[target.Kind] = true
+			ev.Tags = append(ev.Tags, nostr.Tag{"k", strconv.Itoa(target.Kind)})
+		}
+		if t := target.Tags.Find("t"); t != nil && !channelTags[t[1]] {
+			channelTags[t[1]] = true
+			ev.Tags = append(ev.Tags, nostr.Tag{"t", t[1]})
+		}
+	}
+	if err := SignEvent(ctx, &ev, IdentityKey, RelaysForWriting(channel), verbose); err != nil {
+		return err
+	}
+
+	if verbose {
+		fmt.Printf("Publishing deletion for %d events...\n", len(targets))
+	}
+
+	if err := PublishEvent(ctx, RelaysForWriting(channel), ev, verbose); err != nil {
+		fmt.Print("Failure - publish error")
+		return err
+	}
+
+	fmt.Printf("Requested deletion of %d message(s)", len(targets))
+
+	if verbose {
+		fmt.Printf("\nTotal operation time: %dms\n", time.Since(startTime).Milliseconds())
+	}
+
+	return nil
+}
+
+// identityPublicKey returns the public key of the configured identity, which deletions require
+func identityPublicKey() (string, error) {
+	if IdentityKey == "" {
+		return "", fmt.Errorf("deleting messages requires identity-key in pulse.conf")
+	}
+	return nostr.GetPublicKey(IdentityKey)
+}
+
+// ControlFilter returns the filter matching deletion requests and read acks published for a channel
+// Its limit is a page size; readers page back for older ones
+func ControlFilter(key []byte) nostr.Filter {
+	return nostr.Filter{
+		Tags:  nostr.TagMap{"t": []string{hex.EncodeToString(key)}},
+		Kinds: []int{nostr.KindDeletion, KindPulseAck},
+		Limit: 100,
+	}
+}
+
+// ApplyDeletions drops events retracted by a deletion request from the same author (NIP-09)
+func ApplyDeletions(events []*nostr.Event, deletions []*nostr.Event) []*nostr.Event {
+	if len(deletions) == 0 {
+		return events
+	}
+
+	// Keyed by event ID and requesting author, since only the original author may delete
+	deleted := make(map[string]bool)
+	for _, deletion := range deletions {
+		for _, tag := range deletion.Tags {
+			if len(tag) >= 2 && tag[0] == "e" {
+				deleted[tag[1]+":"+deletion.PubKey] = true
+			}
+		}
+	}
+
+	kept := events[:0]
+	for _, ev := range events {
+		if deleted[ev.ID+":"+ev.PubKey] {
+			continue
+		}
+		kept = append(kept, ev)
+	}
+	return kept
+}
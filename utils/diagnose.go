@@ -0,0 +1,232 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip11"
+)
+
+// RelayTestResult holds the outcome of a diagnostic run against a single relay
+type RelayTestResult struct {
+	Relay        string                          `json:"relay"`
+	Connected    bool                            `json:"connected"`
+	ConnectMs    int64                           `json:"connect_ms"`
+	Kind         int                             `json:"kind"`
+	Info         *nip11.RelayInformationDocument `json:"info,omitempty"`
+	InfoError    string                          `json:"info_error,omitempty"`
+	Published    bool                            `json:"published"`
+	PublishMs    int64                           `json:"publish_ms"`
+	TagsIndexed  bool                            `json:"tags_indexed"`
+	ReadBack     bool                            `json:"read_back"`
+	RoundTripMs  int64                           `json:"round_trip_ms"`
+	Error        string                          `json:"error,omitempty"`
+	ProbeEventID string                          `json:"probe_event_id,omitempty"`
+}
+
+// TestRelays runs connectivity, NIP-11, publish and read-back checks against each relay
+// If relays is empty, every configured relay is tested
+func TestRelays(relays []string, verbose bool, jsonOutput bool) error {
+	if len(relays) == 0 {
+		relays = AllRelays()
+	}
+
+	// Status output is the point of this command, so always track it
+	tracker := NewStatusTracker(true)
+	results := make([]*RelayTestResult, len(relays))
+	var wg sync.WaitGroup
+
+	if !jsonOutput {
+		fmt.Println("Testing relays...")
+	}
+
+	for i, url := range relays {
+		tracker.AddRelay(url)
+		wg.Add(1)
+		go func(i int, u string) {
+			defer wg.Done()
+			tracker.UpdateStatus(u, "pending")
+			result := testRelay(context.Background(), u, tracker)
+			results[i] = result
+
+			if result.Error != "" {
+				tracker.UpdateStatusWithReason(u, "error", result.Error)
+			} else {
+				tracker.UpdateStatusWithReason(u, "success", fmt.Sprintf("round trip %dms", result.RoundTripMs))
+			}
+		}(i, url)
+	}
+	wg.Wait()
+
+	if jsonOutput {
+		out, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+	} else {
+		tracker.FinalizeStatus()
+		tracker.DisplayStatus()
+		if verbose {
+			for _, result := range results {
+				printRelayTestDetails(result)
+			}
+		}
+	}
+
+	for _, result := range results {
+		if result.Error == "" {
+			return nil
+		}
+	}
+	return fmt.Errorf("no relay passed all checks")
+}
+
+// testRelay connects to a relay, fetches its NIP-11 document, publishes a probe and reads it back
+// Relays asking for NIP-42 authentication get it, as they would when sending
+func testRelay(ctx context.Context, url string, tracker *StatusTracker) *RelayTestResult {
+	result := &RelayTestResult{Relay: url}
+
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	// NIP-11 is informational, a failure here does not fail the test
+	info, err := RefreshRelayInfo(ctx, url)
+	if err != nil {
+		result.InfoError = err.Error()
+	} else {
+		result.Info = info
+	}
+
+	connectStart := time.Now()
+	connectCtx, connectCancel := context.WithTimeout(ctx, 5*time.Second)
+	r, err := ConnectRelay(connectCtx, url)
+	connectCancel()
+	result.ConnectMs = time.Since(connectStart).Milliseconds()
+	if err != nil {
+		result.Error = "connect: " + err.Error()
+		return result
+	}
+	result.Connected = true
+
+	// Probe a throwaway channel so real channels are never polluted
+	probeBytes := make([]byte, 16)
+	rand.Read(probeBytes)
+	probeID := "pulse-probe-" + hex.EncodeToString(probeBytes)
+	key := DeriveKey(probeID)
+
+	// Mined for this relay alone, so its own proof-of-work requirement is what gets tested
+	ev, err := NewMessageEvent(ctx, probeID, key, "pulse relay probe", nostr.GeneratePrivateKey(), SendOptions{Relays: []string{url}}, false)
+	if err != nil {
+		result.Error = "probe: " + err.Error()
+		return result
+	}
+	result.ProbeEventID = ev.ID
+	result.Kind = ev.Kind
+
+	// Subscribe before publishing so propagation is measured from the live stream
+	sub, err := r.Subscribe(ctx, []nostr.Filter{MessageFilter(probeID, key)})
+	if err != nil {
+		result.Error = "subscribe: " + err.Error()
+		return result
+	}
+	defer sub.Unsub()
+
+	publishStart := time.Now()
+	if err := PublishWithAuth(ctx, r, url, ev, tracker); err != nil {
+		result.PublishMs = time.Since(publishStart).Milliseconds()
+		result.Error = "publish: " + err.Error()
+		return result
+	}
+	result.PublishMs = time.Since(publishStart).Milliseconds()
+	result.Published = true
+
+	timeout := time.After(5 * time.Second)
+Loop:
+	for {
+		select {
+		case reason := <-sub.ClosedReason:
+			if sub, err = ResubscribeAfterClosed(ctx, r, url, sub, reason, tracker); err != nil {
+				result.Error = "subscribe: " + err.Error()
+				return result
+			}
+			defer sub.Unsub()
+		case got, ok := <-sub.Events:
+			if !ok {
+				// The relay dropped the connection, so there is nothing left to read back
+				result.Error = "connection closed"
+				return result
+			}
+			if got.ID == ev.ID {
+				result.RoundTripMs = time.Since(publishStart).Milliseconds()
+				result.TagsIndexed = true
+				break Loop
+			}
+		case <-timeout:
+			break Loop
+		case <-ctx.Done():
+			break Loop
+		}
+	}
+
+	// The whole test ran out of time, so there is none left to query with
+	if ctx.Err() != nil {
+		result.Error = "timed out waiting for the probe"
+		return result
+	}
+
+	// Query the stored copy by ID to tell "not stored" apart from "tag not indexed"
+	queryCtx, queryCancel := context.WithTimeout(ctx, 5*time.Second)
+	stored, err := r.QuerySync(queryCtx, nostr.Filter{IDs: []string{ev.ID}})
+	queryCancel()
+	if err == nil && len(stored) > 0 {
+		result.ReadBack = true
+		if result.RoundTripMs == 0 {
+			result.RoundTripMs = time.Since(publishStart).Milliseconds()
+		}
+	}
+
+	switch {
+	case !result.ReadBack && !result.TagsIndexed:
+		result.Error = "probe accepted but not served back"
+	case !result.TagsIndexed:
+		result.Error = "probe stored but not matched by tag filter"
+	}
+
+	return result
+}
+
+// printRelayTestDetails prints the per-step breakdown for a relay test
+func printRelayTestDetails(result *RelayTestResult) {
+	fmt.Printf("\n%s\n", result.Relay)
+	fmt.Printf("  connect:    %s (%dms)\n", passFail(result.Connected), result.ConnectMs)
+	if result.Info != nil {
+		software := strings.TrimSpace(result.Info.Software + " " + result.Info.Version)
+		if software == "" {
+			software = "unknown software"
+		}
+		fmt.Printf("  nip-11:     ok (%s)\n", software)
+	} else {
+		fmt.Printf("  nip-11:     unavailable (%s)\n", result.InfoError)
+	}
+	fmt.Printf("  publish:    %s (%dms, kind %d)\n", passFail(result.Published), result.PublishMs, result.Kind)
+	fmt.Printf("  tag filter: %s\n", passFail(result.TagsIndexed))
+	fmt.Printf("  read back:  %s\n", passFail(result.ReadBack))
+	if result.RoundTripMs > 0 {
+		fmt.Printf("  round trip: %dms\n", result.RoundTripMs)
+	}
+}
+
+func passFail(ok bool) string {
+	if ok {
+		return "ok"
+	}
+	return "failed"
+}
@@ -0,0 +1,24 @@
+package utils
+
+import (
+	"context"
+	"testing"
+
+	"pulse/internal/relaytest"
+)
+
+func TestRelayProbe(t *testing.T) {
+	relay := relaytest.New(t)
+	useRelays(t, relay.URL)
+
+	result := testRelay(context.Background(), relay.URL, NewStatusTracker(false))
+	if result.Error != "" || !result.Connected || !result.Published || !result.TagsIndexed || !result.ReadBack {
+		t.Fatalf("probe of a working relay: %+v", result)
+	}
+
+	// The pooled connection outlives the probe, for whatever the program does next
+	if n := relay.OpenConnections(); n != 1 {
+		t.Errorf("%d connections open after the probe, want the pooled one", n)
+	}
+	relaytest.WaitFor(t, "the probe subscription to end", func() bool { return relay.Subscriptions() == 0 })
+}
@@ -9,8 +9,9 @@ import (
 // RelayStatus represents the status of a relay operation
 type RelayStatus struct {
 	Name      string
-	Status    string // "pending", "success", "cancelled", "error"
+	Status    string // "pending", "success", "cancelled", "skipped", "error"
 	Reason    string
+	Auth      string // NIP-42 state, empty if the relay never asked
 	Duration  time.Duration
 	StartTime time.Time
 }
@@ -20,6 +21,7 @@ type StatusTracker struct {
 	mu               sync.Mutex
 	relays           map[string]*RelayStatus
 	verbose          bool
+	log              func(format string, args ...any) // told about each relay as it finishes
 	operationStart   time.Time
 	firstResultTime  time.Duration
 	firstResultReady bool
@@ -36,9 +38,21 @@ func NewStatusTracker(verbose bool) *StatusTracker {
 	return st
 }
 
+// newStatusTracker creates a status tracker that also reports to the settings' log
+func (s *Settings) newStatusTracker(verbose bool) *StatusTracker {
+	st := NewStatusTracker(verbose)
+	st.log = s.Log
+	return st
+}
+
+// tracking reports whether relay statuses are recorded at all
+func (st *StatusTracker) tracking() bool {
+	return st.verbose || st.log != nil
+}
+
 // AddRelay initializes a relay in the tracker
 func (st *StatusTracker) AddRelay(name string) {
-	if !st.verbose {
+	if !st.tracking() {
 		return
 	}
 	st.mu.Lock()
@@ -57,7 +71,7 @@ func (st *StatusTracker) UpdateStatus(name string, status string) {
 
 // UpdateStatusWithReason updates the status of a relay with a reason
 func (st *StatusTracker) UpdateStatusWithReason(name string, status string, reason string) {
-	if !st.verbose {
+	if !st.tracking() {
 		return
 	}
 	st.mu.Lock()
@@ -70,6 +84,21 @@ func (st *StatusTracker) UpdateStatusWithReason(name string, status string, reas
 			st.firstResultTime = time.Since(st.operationStart)
 			st.firstResultReady = true
 		}
+		if st.log != nil && status != "pending" {
+			st.log("%s", relay.line())
+		}
+	}
+	st.mu.Unlock()
+}
+
+// SetAuthState records the NIP-42 authentication state of a relay
+func (st *StatusTracker) SetAuthState(name string, state string) {
+	if !st.tracking() {
+		return
+	}
+	st.mu.Lock()
+	if relay, exists := st.relays[name]; exists {
+		relay.Auth = state
 	}
 	st.mu.Unlock()
 }
@@ -102,24 +131,34 @@ func (st *StatusTracker) DisplayStatus() {
 	defer st.mu.Unlock()
 
 	for _, relay := range st.relays {
-		icon := ""
-		switch relay.Status {
-		case "pending":
-			icon = "⟳"
-		case "success":
-			icon = "✓"
-		case "cancelled":
-			icon = "✗"
-		case "error":
-			icon = "✗"
-		}
+		fmt.Println(relay.line())
+	}
+}
 
-		line := fmt.Sprintf("[%s] %-30s %dms", icon, relay.Name, relay.Duration.Milliseconds())
-		if relay.Reason != "" {
-			line += fmt.Sprintf(" (%s)", relay.Reason)
-		}
-		fmt.Println(line)
+// line formats a relay's status as DisplayStatus shows it
+func (relay *RelayStatus) line() string {
+	icon := ""
+	switch relay.Status {
+	case "pending":
+		icon = "⟳"
+	case "success":
+		icon = "✓"
+	case "cancelled":
+		icon = "✗"
+	case "skipped":
+		icon = "-"
+	case "error":
+		icon = "✗"
+	}
+
+	line := fmt.Sprintf("[%s] %-30s %dms", icon, relay.Name, relay.Duration.Milliseconds())
+	if relay.Reason != "" {
+		line += fmt.Sprintf(" (%s)", relay.Reason)
+	}
+	if relay.Auth != "" {
+		line += fmt.Sprintf(" [auth: %s]", relay.Auth)
 	}
+	return line
 }
 
 // GetTotalDuration returns the time from operation start to first result
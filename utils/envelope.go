@@ -0,0 +1,123 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip40"
+)
+
+// EnvelopeVersion marks a decrypted payload as a Pulse envelope rather than a bare message
+const EnvelopeVersion = 1
+
+var ErrMessageExpired = errors.New("message expired")
+
+// Envelope is the encrypted payload of a message, carrying metadata alongside the text
+// Messages without metadata are still sent as bare text so older readers can decrypt them,
+// unless compression is turned on and shrinks them
+type Envelope struct {
+	Pulse       int        `json:"pulse"`
+	Body        string     `json:"body"`
+	Expires     int64      `json:"expires,omitempty"`
+	BurnKey     string     `json:"burn_key,omitempty"`    // burn-after-read: key the reader signs the ack and deletion with
+	Compression string     `json:"compression,omitempty"` // set when the body is compressed and base64-encoded
+	RPC         *RPCHeader `json:"rpc,omitempty"`
+	InReplyTo   string     `json:"in_reply_to,omitempty"` // event ID of the message this one answers
+}
+
+// hasMetadata reports whether the envelope needs to be sent as JSON
+func (e *Envelope) hasMetadata() bool {
+	return e.Expires != 0 || e.BurnKey != "" || e.RPC != nil || e.InReplyTo != ""
+}
+
+// needsEnvelope reports whether the envelope must be sent as JSON: it has metadata, or its body
+// would itself be read as an envelope if sent bare
+func (e *Envelope) needsEnvelope() bool {
+	if e.hasMetadata() {
+		return true
+	}
+	var inner Envelope
+	return json.Unmarshal([]byte(e.Body), &inner) == nil && inner.Pulse == EnvelopeVersion
+}
+
+// Once reports whether the message should be consumed by its first reader
+func (e *Envelope) Once() bool {
+	return e.BurnKey != ""
+}
+
+// Expired reports whether the sender's expiry has passed
+func (e *Envelope) Expired() bool {
+	return e.Expires != 0 && time.Now().Unix() >= e.Expires
+}
+
+// SealMessage wraps a message in an envelope when needed and encrypts it
+// The body is compressed only when that makes the plaintext smaller
+func (s *Settings) SealMessage(env *Envelope, key []byte) (string, error) {
+	plaintext := env.Body
+	if env.needsEnvelope() {
+		env.Pulse = EnvelopeVersion
+		data, err := json.Marshal(env)
+		if err != nil {
+			return "", err
+		}
+		plaintext = string(data)
+	}
+
+	if s.Compression != CompressionNone {
+		body, err := compressBody(env.Body)
+		if err != nil {
+			return "", err
+		}
+		compressed := *env
+		compressed.Pulse = EnvelopeVersion
+		compressed.Body = body
+		compressed.Compression = s.Compression
+		data, err := json.Marshal(&compressed)
+		if err != nil {
+			return "", err
+		}
+		if len(data) < len(plaintext) {
+			plaintext = string(data)
+		}
+	}
+
+	return Encrypt(plaintext, key)
+}
+
+// OpenMessage is a wrapper around CurrentSettings().OpenMessage
+func OpenMessage(ev *nostr.Event, key []byte) (*Envelope, error) {
+	return CurrentSettings().OpenMessage(ev, key)
+}
+
+// OpenMessage decrypts an event and unwraps its envelope
+// It returns ErrMessageExpired for messages past their NIP-40 or envelope expiry
+func (s *Settings) OpenMessage(ev *nostr.Event, key []byte) (*Envelope, error) {
+	if exp := nip40.GetExpiration(ev.Tags); exp != -1 && nostr.Now() >= exp {
+		return nil, ErrMessageExpired
+	}
+
+	plaintext, err := Decrypt(ev.Content, key)
+	if err != nil {
+		return nil, err
+	}
+
+	env := &Envelope{}
+	if err := json.Unmarshal([]byte(plaintext), env); err != nil || env.Pulse != EnvelopeVersion {
+		// Bare message from a sender without metadata, or from an older version
+		return &Envelope{Body: plaintext}, nil
+	}
+	if env.Expired() {
+		return nil, ErrMessageExpired
+	}
+	if env.Compression != "" {
+		body, err := decompressBody(env.Body, env.Compression, s.MaxMessageSize)
+		if err != nil {
+			return nil, err
+		}
+		env.Body = body
+		env.Compression = ""
+	}
+	return env, nil
+}
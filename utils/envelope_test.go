@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	key := DeriveKey("envelope-test")
+	tests := []struct {
+		name string
+		env  Envelope
+	}{
+		{"bare text", Envelope{Body: "hello"}},
+		{"JSON body", Envelope{Body: `{"status":"ok"}`}},
+		{"body that looks like an envelope", Envelope{Body: `{"pulse":1,"status":"ok"}`}},
+		{"body that looks like a full envelope", Envelope{Body: `{"pulse":1,"body":"not this","burn_key":"x"}`}},
+		{"body with metadata", Envelope{Body: `{"pulse":1}`, InReplyTo: "abc"}},
+	}
+	for _, compression := range []string{CompressionNone, CompressionDeflate} {
+		s := DefaultSettings()
+		s.Compression = compression
+		for _, test := range tests {
+			t.Run(compression+"/"+test.name, func(t *testing.T) {
+				env := test.env
+				content, err := s.SealMessage(&env, key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				opened, err := s.OpenMessage(&nostr.Event{Content: content}, key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if opened.Body != test.env.Body || opened.InReplyTo != test.env.InReplyTo || opened.BurnKey != "" {
+					t.Errorf("opened %+v, want body %q", opened, test.env.Body)
+				}
+			})
+		}
+	}
+}
+
+func TestBareBodiesStayBare(t *testing.T) {
+	// Older readers show the decrypted text as is, so plain bodies must not gain a wrapper
+	key := DeriveKey("envelope-test")
+	content, err := DefaultSettings().SealMessage(&Envelope{Body: `{"status":"ok"}`}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := Decrypt(content, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plaintext != `{"status":"ok"}` {
+		t.Errorf("plaintext = %q, want the bare body", plaintext)
+	}
+}
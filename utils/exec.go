@@ -0,0 +1,195 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// shellCommand returns the arguments that run a command line through the system shell
+func shellCommand(command string) ([]string, error) {
+	if strings.TrimSpace(command) == "" {
+		return nil, fmt.Errorf("command is empty")
+	}
+	if runtime.GOOS == "windows" {
+		return []string{"cmd", "/C", command}, nil
+	}
+	return []string{"sh", "-c", command}, nil
+}
+
+// runCommand runs a handler with input on stdin and extra environment variables, returning its stdout
+// timeout 0 means the command may run until the context ends
+func runCommand(ctx context.Context, args []string, input string, env []string, timeout time.Duration) ([]byte, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), env...)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+	return stdout.Bytes(), err
+}
+
+// execRunner runs a command for each listened message, a limited number at a time
+type execRunner struct {
+	args    []string
+	opts    ListenOptions
+	cursor  *Cursor
+	verbose bool
+
+	slots chan struct{}
+	wg    sync.WaitGroup
+
+	mu       sync.Mutex
+	seq      int
+	next     int              // sequence number of the first message the cursor hasn't passed
+	finished map[int]*Message // messages done but waiting for an earlier one, by sequence number
+	stopAt   int              // first message the cursor must stay before, after a failure; -1 while none
+}
+
+func newExecRunner(cursor *Cursor, opts ListenOptions, verbose bool) (*execRunner, error) {
+	args, err := shellCommand(opts.Exec)
+	if err != nil {
+		return nil, err
+	}
+	concurrency := max(opts.ExecConcurrency, 1)
+	return &execRunner{
+		args:     args,
+		opts:     opts,
+		cursor:   cursor,
+		verbose:  verbose,
+		slots:    make(chan struct{}, concurrency),
+		finished: make(map[int]*Message),
+		stopAt:   -1,
+	}, nil
+}
+
+// Run starts the command for a message, waiting for a free slot first
+func (r *execRunner) Run(ctx context.Context, msg *Message) {
+	select {
+	case r.slots <- struct{}{}:
+	case <-ctx.Done():
+		return
+	}
+
+	r.mu.Lock()
+	seq := r.seq
+	r.seq++
+	r.mu.Unlock()
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer func() { <-r.slots }()
+
+		env := []string{
+			"PULSE_CHANNEL=" + msg.Channel,
+			"PULSE_EVENT_ID=" + msg.ID,
+			"PULSE_AUTHOR=" + msg.Author,
+			"PULSE_CREATED_AT=" + strconv.FormatInt(msg.CreatedAt, 10),
+		}
+		output, err := runCommand(ctx, r.args, msg.Body, env, r.opts.ExecTimeout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s: %v\n", msg.ID, r.opts.Exec, err)
+		} else if r.opts.ExecReply {
+			err = r.reply(ctx, msg, output)
+		} else {
+			r.mu.Lock()
+			os.Stdout.Write(output)
+			r.mu.Unlock()
+		}
+
+		r.complete(seq, msg, err != nil)
+	}()
+}
+
+// Skip records a message that needs no command, so the cursor can move past it in order
+func (r *execRunner) Skip(msg *Message) {
+	r.mu.Lock()
+	seq := r.seq
+	r.seq++
+	r.mu.Unlock()
+
+	r.complete(seq, msg, false)
+}
+
+// reply publishes the command's output as a message on the message's channel
+func (r *execRunner) reply(ctx context.Context, msg *Message, output []byte) error {
+	text := strings.TrimRight(string(output), "\n")
+	if text == "" {
+		return nil
+	}
+	if _, err := CurrentSettings().SendMessage(ctx, msg.Channel, text, SendOptions{InReplyTo: msg.ID}, r.verbose); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: reply: %v\n", msg.ID, err)
+		return err
+	}
+	return nil
+}
+
+// complete advances the cursor over messages whose commands have all finished, in order
+// Commands finish out of order when several run at once, and the cursor must never pass a
+// message that is still running or failed; only messages it may still pass are remembered
+func (r *execRunner) complete(seq int, msg *Message, failed bool) {
+	if r.cursor == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if failed {
+		r.stop(seq)
+	}
+	if r.stopAt >= 0 && seq >= r.stopAt {
+		return
+	}
+
+	r.finished[seq] = msg
+	for r.next != r.stopAt {
+		done, ok := r.finished[r.next]
+		if !ok {
+			break
+		}
+		if err := r.cursor.Advance(done); err != nil {
+			fmt.Fprintf(os.Stderr, "cursor: %v\n", err)
+			r.stop(r.next)
+			break
+		}
+		delete(r.finished, r.next)
+		r.next++
+	}
+}
+
+// stop keeps the cursor before a message for good and forgets what finished after it
+func (r *execRunner) stop(seq int) {
+	if r.stopAt >= 0 && r.stopAt <= seq {
+		return
+	}
+	r.stopAt = seq
+	for pending := range r.finished {
+		if pending >= seq {
+			delete(r.finished, pending)
+		}
+	}
+}
+
+// Wait blocks until every started command has finished
+func (r *execRunner) Wait() {
+	r.wg.Wait()
+}
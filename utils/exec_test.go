@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestExecRunnerCursorOrder(t *testing.T) {
+	cursor := &Cursor{Name: "exec-runner-test"}
+	r := &execRunner{cursor: cursor, finished: make(map[int]*Message), stopAt: -1}
+	messages := make([]*Message, 50)
+	for i := range messages {
+		messages[i] = &Message{ID: fmt.Sprintf("m%d", i), CreatedAt: int64(1000 + i)}
+	}
+
+	// Out of order: the cursor waits for the first command
+	r.complete(1, messages[1], false)
+	if cursor.ID != "" || len(r.finished) != 1 {
+		t.Fatalf("cursor at %q with %d pending, want nothing passed and 1 pending", cursor.ID, len(r.finished))
+	}
+	r.complete(0, messages[0], false)
+	if cursor.ID != "m1" || len(r.finished) != 0 {
+		t.Fatalf("cursor at %q with %d pending, want m1 and none", cursor.ID, len(r.finished))
+	}
+
+	// A failure stops the cursor before it, and nothing after it is kept around
+	r.complete(4, messages[4], false)
+	r.complete(3, messages[3], true)
+	if len(r.finished) != 0 {
+		t.Errorf("%d messages kept after the failed one", len(r.finished))
+	}
+	r.complete(2, messages[2], false)
+	for seq := 5; seq < len(messages); seq++ {
+		r.complete(seq, messages[seq], false)
+	}
+	if cursor.ID != "m2" {
+		t.Errorf("cursor at %q, want m2 just before the failure", cursor.ID)
+	}
+	if len(r.finished) != 0 {
+		t.Errorf("%d finished messages kept although the cursor can't pass them", len(r.finished))
+	}
+}
+
+func TestExecRunnerWithoutCursor(t *testing.T) {
+	r := &execRunner{finished: make(map[int]*Message), stopAt: -1}
+	for seq := 10; seq > 0; seq-- {
+		r.complete(seq, &Message{ID: fmt.Sprint(seq)}, seq%3 == 0)
+	}
+	if len(r.finished) != 0 {
+		t.Errorf("%d messages kept without a cursor", len(r.finished))
+	}
+}
@@ -0,0 +1,462 @@
+package utils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Regular kinds for file transfers, kept apart from messages so retrieve never shows chunks
+const (
+	KindPulseChunk = 4244
+	KindPulseFile  = 4245
+)
+
+var FileChunkSize = 12 * 1024 // compressed bytes per chunk, about 24KB of event content once encrypted
+var FilePublishWorkers = 4    // chunks in flight per relay
+var fileQueryBatch = 100      // chunks requested per query when downloading
+
+// FileManifest describes a transfer; it is published after every chunk has been accepted
+type FileManifest struct {
+	Transfer    string `json:"transfer"`
+	Name        string `json:"name"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+	Chunks      int    `json:"chunks"`
+	Compression string `json:"compression"`
+}
+
+// chunkTag is the indexable tag that lets a reader ask for exactly the chunks it is missing
+func chunkTag(transfer string, index int) string {
+	return transfer + ":" + strconv.Itoa(index)
+}
+
+// SendFile compresses, encrypts and splits a file into chunk events, then publishes its manifest
+func SendFile(id string, path string, verbose bool) error {
+	startTime := time.Now()
+	key := DeriveKey(id)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write(data)
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	transferBytes := make([]byte, 16)
+	rand.Read(transferBytes)
+	sum := sha256.Sum256(data)
+	manifest := FileManifest{
+		Transfer:    hex.EncodeToString(transferBytes),
+		Name:        filepath.Base(path),
+		Size:        int64(len(data)),
+		SHA256:      hex.EncodeToString(sum[:]),
+		Chunks:      (compressed.Len() + FileChunkSize - 1) / FileChunkSize,
+		Compression: "gzip",
+	}
+
+	if verbose {
+		fmt.Printf("Sending %s: %d bytes, %d compressed, %d chunks\n", manifest.Name, manifest.Size, compressed.Len(), manifest.Chunks)
+	}
+
+	// Every event of a transfer shares one key, so readers can ignore chunks injected by others
+	sk := SigningKey()
+	relays := RelaysForWriting(id)
+	hashedTag := hex.EncodeToString(key)
+
+	chunks := make([]nostr.Event, manifest.Chunks)
+	payload := compressed.Bytes()
+	for i := range chunks {
+		end := min((i+1)*FileChunkSize, len(payload))
+		encrypted, err := Encrypt(string(payload[i*FileChunkSize:end]), key)
+		if err != nil {
+			return err
+		}
+		chunks[i] = nostr.Event{
+			CreatedAt: nostr.Now(),
+			Kind:      KindPulseChunk,
+			Tags:      nostr.Tags{{"t", hashedTag}, {"x", chunkTag(manifest.Transfer, i)}},
+			Content:   encrypted,
+		}
+		if err := SignEvent(ctx, &chunks[i], sk, relays, false); err != nil {
+			return err
+		}
+	}
+
+	if err := publishChunks(ctx, relays, chunks, verbose); err != nil {
+		fmt.Print("Failure - publish error")
+		return err
+	}
+
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	encrypted, err := Encrypt(string(manifestJSON), key)
+	if err != nil {
+		return err
+	}
+	ev := nostr.Event{
+		CreatedAt: nostr.Now(),
+		Kind:      KindPulseFile,
+		Tags:      nostr.Tags{{"t", hashedTag}, {"x", manifest.Transfer}},
+		Content:   encrypted,
+	}
+	if err := SignEvent(ctx, &ev, sk, relays, verbose); err != nil {
+		return err
+	}
+	if err := PublishEvent(ctx, relays, ev, verbose); err != nil {
+		fmt.Print("Failure - publish error")
+		return err
+	}
+
+	fmt.Print("Success")
+
+	if verbose {
+		fmt.Printf("\nTotal operation time: %dms\n", time.Since(startTime).Milliseconds())
+	}
+
+	return nil
+}
+
+// publishChunks publishes chunk events over one connection per relay with several in flight
+// It fails unless every chunk was accepted by at least one relay
+func publishChunks(ctx context.Context, relays []string, chunks []nostr.Event, verbose bool) error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	accepted := make([]bool, len(chunks))
+	done := 0
+
+	tracker := NewStatusTracker(verbose)
+	progress := newProgress("Uploading", len(chunks))
+
+	for _, url := range relays {
+		tracker.AddRelay(url)
+		wg.Add(1)
+		go func(u string) {
+			defer wg.Done()
+			tracker.UpdateStatus(u, "pending")
+
+			// Chunks are the same size, so the first one stands for all of them
+			if info, _ := GetRelayInfo(ctx, u); info != nil && len(chunks) > 0 {
+				if err := CheckEventLimits(u, info, chunks[0]); err != nil {
+					tracker.UpdateStatusWithReason(u, "skipped", err.Error())
+					return
+				}
+			}
+
+			r, err := ConnectRelay(ctx, u)
+			if err != nil {
+				tracker.UpdateStatusWithReason(u, "error", err.Error())
+				return
+			}
+
+			queue := make(chan int)
+			var failMu sync.Mutex
+			var lastErr error
+			failed := 0
+
+			var workers sync.WaitGroup
+			for w := 0; w < FilePublishWorkers; w++ {
+				workers.Add(1)
+				go func() {
+					defer workers.Done()
+					for i := range queue {
+						if err := PublishWithAuth(ctx, r, u, chunks[i], tracker); err != nil {
+							failMu.Lock()
+							failed++
+							lastErr = err
+							failMu.Unlock()
+							continue
+						}
+						mu.Lock()
+						if !accepted[i] {
+							accepted[i] = true
+							done++
+							progress.Update(done)
+						}
+						mu.Unlock()
+					}
+				}()
+			}
+			for i := range chunks {
+				queue <- i
+			}
+			close(queue)
+			workers.Wait()
+
+			if failed > 0 {
+				tracker.UpdateStatusWithReason(u, "error", fmt.Sprintf("%d of %d chunks failed: %s", failed, len(chunks), lastErr))
+			} else {
+				tracker.UpdateStatusWithReason(u, "success", fmt.Sprintf("%d chunks published", len(chunks)))
+			}
+		}(url)
+	}
+	wg.Wait()
+	progress.Finish()
+
+	if verbose {
+		tracker.FinalizeStatus()
+		tracker.DisplayStatus()
+		fmt.Printf("Total time: %dms\n", tracker.GetTotalDuration().Milliseconds())
+	}
+
+	if done < len(chunks) {
+		return fmt.Errorf("%d of %d chunks were not accepted by any relay", len(chunks)-done, len(chunks))
+	}
+	return nil
+}
+
+// GetFile downloads the newest file sent to an ID, resuming from chunks saved by earlier attempts
+// output "" uses the sender's file name in the current directory and "-" writes to stdout
+func GetFile(id string, output string, verbose bool) error {
+	startTime := time.Now()
+	key := DeriveKey(id)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if verbose {
+		fmt.Println("Looking up file...")
+	}
+
+	relays := RelaysForReading(id)
+	manifests, err := QueryRelays(ctx, relays, nostr.Filter{
+		Kinds: []int{KindPulseFile},
+		Tags:  nostr.TagMap{"t": []string{hex.EncodeToString(key)}},
+	}, verbose)
+	if err != nil {
+		return err
+	}
+
+	// Newest manifest that decrypts with this channel's key
+	sort.Slice(manifests, func(i, j int) bool {
+		return manifests[i].CreatedAt > manifests[j].CreatedAt
+	})
+	var manifest *FileManifest
+	var sender string
+	for _, ev := range manifests {
+		plaintext, err := Decrypt(ev.Content, key)
+		if err != nil {
+			continue
+		}
+		m := &FileManifest{}
+		if err := json.Unmarshal([]byte(plaintext), m); err != nil || m.Chunks <= 0 || m.Transfer == "" {
+			continue
+		}
+		manifest, sender = m, ev.PubKey
+		break
+	}
+	if manifest == nil {
+		return fmt.Errorf("no file found")
+	}
+	if manifest.Compression != "gzip" {
+		return fmt.Errorf("unsupported compression %q", manifest.Compression)
+	}
+
+	if verbose {
+		fmt.Printf("Receiving %s: %d bytes, %d chunks\n", manifest.Name, manifest.Size, manifest.Chunks)
+	}
+
+	stateDir, err := transferDir(manifest.Transfer)
+	if err != nil {
+		return err
+	}
+
+	var missing []int
+	for i := 0; i < manifest.Chunks; i++ {
+		if _, err := os.Stat(chunkPath(stateDir, i)); err != nil {
+			missing = append(missing, i)
+		}
+	}
+	if verbose && len(missing) < manifest.Chunks {
+		fmt.Printf("Resuming: %d of %d chunks already downloaded\n", manifest.Chunks-len(missing), manifest.Chunks)
+	}
+
+	progress := newProgress("Downloading", manifest.Chunks)
+	have := manifest.Chunks - len(missing)
+	progress.Update(have)
+
+	for start := 0; start < len(missing); start += fileQueryBatch {
+		batch := missing[start:min(start+fileQueryBatch, len(missing))]
+		wanted := make(map[string]int, len(batch))
+		var tags []string
+		for _, i := range batch {
+			tag := chunkTag(manifest.Transfer, i)
+			wanted[tag] = i
+			tags = append(tags, tag)
+		}
+
+		events, err := QueryRelays(ctx, relays, nostr.Filter{
+			Kinds:   []int{KindPulseChunk},
+			Authors: []string{sender},
+			Tags:    nostr.TagMap{"x": tags},
+		}, false)
+		if err != nil {
+			return err
+		}
+
+		for _, ev := range events {
+			tag := ev.Tags.Find("x")
+			if tag == nil {
+				continue
+			}
+			i, ok := wanted[tag[1]]
+			if !ok {
+				continue
+			}
+			plaintext, err := Decrypt(ev.Content, key)
+			if err != nil {
+				continue
+			}
+			if err := writeFileAtomic(chunkPath(stateDir, i), []byte(plaintext), 0600); err != nil {
+				return err
+			}
+			delete(wanted, tag[1])
+			have++
+			progress.Update(have)
+		}
+	}
+	progress.Finish()
+
+	if have < manifest.Chunks {
+		return fmt.Errorf("%d of %d chunks missing, run get-file again to resume", manifest.Chunks-have, manifest.Chunks)
+	}
+
+	data, err := assembleFile(stateDir, manifest)
+	if err != nil {
+		return err
+	}
+
+	if output == "-" {
+		os.Stdout.Write(data)
+	} else {
+		if output == "" {
+			output = filepath.Base(manifest.Name)
+			if output == "." || output == string(filepath.Separator) {
+				output = manifest.Transfer
+			}
+		}
+		if err := writeFileAtomic(output, data, 0644); err != nil {
+			return err
+		}
+		fmt.Printf("Saved %s (%d bytes)", output, len(data))
+	}
+	os.RemoveAll(stateDir)
+
+	if verbose {
+		fmt.Printf("\nTotal operation time: %dms\n", time.Since(startTime).Milliseconds())
+	}
+
+	return nil
+}
+
+// assembleFile joins the downloaded chunks, decompresses them and verifies size and hash
+// Chunks that don't add up to the announced file are discarded, so the next attempt fetches them again
+func assembleFile(stateDir string, manifest *FileManifest) ([]byte, error) {
+	var compressed bytes.Buffer
+	for i := 0; i < manifest.Chunks; i++ {
+		chunk, err := os.ReadFile(chunkPath(stateDir, i))
+		if err != nil {
+			return nil, err
+		}
+		compressed.Write(chunk)
+	}
+
+	zr, err := gzip.NewReader(&compressed)
+	if err != nil {
+		os.RemoveAll(stateDir)
+		return nil, fmt.Errorf("corrupt file, discarded downloaded chunks: %w", err)
+	}
+	// Never inflate past the announced size, so a bad manifest can't exhaust memory
+	data, err := io.ReadAll(io.LimitReader(zr, manifest.Size+1))
+	if err != nil {
+		os.RemoveAll(stateDir)
+		return nil, fmt.Errorf("corrupt file, discarded downloaded chunks: %w", err)
+	}
+	if int64(len(data)) != manifest.Size {
+		os.RemoveAll(stateDir)
+		return nil, fmt.Errorf("size mismatch: got %d bytes, expected %d, discarded downloaded chunks", len(data), manifest.Size)
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != manifest.SHA256 {
+		os.RemoveAll(stateDir)
+		return nil, fmt.Errorf("checksum mismatch, discarded downloaded chunks")
+	}
+	return data, nil
+}
+
+// transferDir returns the directory holding the chunks of a partial download
+func transferDir(transfer string) (string, error) {
+	if strings.ContainsAny(transfer, `/\.`) {
+		return "", fmt.Errorf("invalid transfer ID %q", transfer)
+	}
+	dir, err := GetDataDir()
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, "transfers", transfer)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+func chunkPath(dir string, index int) string {
+	return filepath.Join(dir, fmt.Sprintf("%06d.chunk", index))
+}
+
+// writeFileAtomic writes through a temporary file so an interrupted write never leaves a partial file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// progress prints a single updating line on stderr, keeping stdout clean for output
+type progress struct {
+	label string
+	total int
+	last  time.Time
+}
+
+func newProgress(label string, total int) *progress {
+	return &progress{label: label, total: total}
+}
+
+// Update redraws the line at most every 100ms, and always for the final chunk
+func (p *progress) Update(done int) {
+	if done < p.total && time.Since(p.last) < 100*time.Millisecond {
+		return
+	}
+	p.last = time.Now()
+	fmt.Fprintf(os.Stderr, "\r\033[K%s: %d/%d chunks (%d%%)", p.label, done, p.total, done*100/max(p.total, 1))
+}
+
+// Finish ends the progress line
+func (p *progress) Finish() {
+	fmt.Fprint(os.Stderr, "\r\033[K")
+}
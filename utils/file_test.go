@@ -0,0 +1,167 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"pulse/internal/relaytest"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// sendTestFile sends size bytes of incompressible data through the relay in small chunks
+// It returns the data, the transfer's manifest and its chunk events by index
+func sendTestFile(t *testing.T, relay *relaytest.Relay, id string, size int) ([]byte, *FileManifest, []*nostr.Event) {
+	t.Helper()
+	chunkSize := FileChunkSize
+	FileChunkSize = 256
+	t.Cleanup(func() { FileChunkSize = chunkSize })
+
+	data := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(data)
+	path := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	captureStdout(t)
+	if err := SendFile(id, path, false); err != nil {
+		t.Fatal(err)
+	}
+
+	key := DeriveKey(id)
+	tag := hex.EncodeToString(key)
+	files := relay.Events(nostr.Filter{Kinds: []int{KindPulseFile}, Tags: nostr.TagMap{"t": {tag}}})
+	if len(files) != 1 {
+		t.Fatalf("%d manifests on the relay, want 1", len(files))
+	}
+	plaintext, err := Decrypt(files[0].Content, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := &FileManifest{}
+	if err := json.Unmarshal([]byte(plaintext), manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Chunks < 3 {
+		t.Fatalf("file went out in %d chunks, want several", manifest.Chunks)
+	}
+	t.Cleanup(func() {
+		if dir, err := transferDir(manifest.Transfer); err == nil {
+			os.RemoveAll(dir)
+		}
+	})
+
+	chunks := make([]*nostr.Event, manifest.Chunks)
+	for i := range chunks {
+		found := relay.Events(nostr.Filter{Kinds: []int{KindPulseChunk}, Tags: nostr.TagMap{"x": {chunkTag(manifest.Transfer, i)}}})
+		if len(found) != 1 {
+			t.Fatalf("chunk %d is on the relay %d times", i, len(found))
+		}
+		chunks[i] = found[0]
+	}
+	return data, manifest, chunks
+}
+
+func TestFileRoundTrip(t *testing.T) {
+	relay := relaytest.New(t)
+	useRelays(t, relay.URL)
+	data, manifest, _ := sendTestFile(t, relay, "file-round-trip-test", 2000)
+
+	output := filepath.Join(t.TempDir(), "out.bin")
+	if err := GetFile("file-round-trip-test", output, false); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("received %d bytes that don't match the %d sent", len(got), len(data))
+	}
+	dir, _ := transferDir(manifest.Transfer)
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("transfer state left behind: %d entries", len(entries))
+	}
+}
+
+func TestGetFileResumes(t *testing.T) {
+	relay := relaytest.New(t)
+	useRelays(t, relay.URL)
+	const id = "file-resume-test"
+	data, manifest, chunks := sendTestFile(t, relay, id, 2000)
+	output := filepath.Join(t.TempDir(), "out.bin")
+
+	// The first attempt finds every chunk but one
+	relay.Remove(chunks[1].ID)
+	if err := GetFile(id, output, false); err == nil || !strings.Contains(err.Error(), "1 of") {
+		t.Fatalf("get-file with a chunk missing: err = %v, want it to report the missing chunk", err)
+	}
+	if _, err := os.Stat(output); err == nil {
+		t.Error("an incomplete file was written")
+	}
+
+	// Only the missing chunk is left to fetch, so the rest must come from pulse-data/transfers
+	for i, chunk := range chunks {
+		if i != 1 {
+			relay.Remove(chunk.ID)
+		}
+	}
+	relay.Publish(chunks[1])
+	if err := GetFile(id, output, false); err != nil {
+		t.Fatalf("resuming: %v", err)
+	}
+	if got, _ := os.ReadFile(output); !bytes.Equal(got, data) {
+		t.Error("resumed file doesn't match what was sent")
+	}
+	dir, _ := transferDir(manifest.Transfer)
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("transfer state left behind: %d entries", len(entries))
+	}
+}
+
+func TestGetFileDiscardsCorruptChunks(t *testing.T) {
+	relay := relaytest.New(t)
+	useRelays(t, relay.URL)
+	const id = "file-corrupt-test"
+	data, manifest, chunks := sendTestFile(t, relay, id, 2000)
+	output := filepath.Join(t.TempDir(), "out.bin")
+
+	relay.Remove(chunks[2].ID)
+	if err := GetFile(id, output, false); err == nil {
+		t.Fatal("get-file with a chunk missing succeeded")
+	}
+
+	// A saved chunk goes bad on disk before the download is resumed
+	dir, _ := transferDir(manifest.Transfer)
+	saved, err := os.ReadFile(chunkPath(dir, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved[len(saved)/2] ^= 0xff
+	if err := os.WriteFile(chunkPath(dir, 1), saved, 0600); err != nil {
+		t.Fatal(err)
+	}
+	relay.Publish(chunks[2])
+
+	err = GetFile(id, output, false)
+	if err == nil || !strings.Contains(err.Error(), "discarded") {
+		t.Fatalf("assembling a corrupt chunk: err = %v, want it discarded", err)
+	}
+	if _, err := os.Stat(output); err == nil {
+		t.Error("a corrupt file was written")
+	}
+
+	// With the bad state gone, the next attempt downloads everything again
+	if err := GetFile(id, output, false); err != nil {
+		t.Fatalf("retrying after the corrupt chunk: %v", err)
+	}
+	if got, _ := os.ReadFile(output); !bytes.Equal(got, data) {
+		t.Error("retried file doesn't match what was sent")
+	}
+}
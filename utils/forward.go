@@ -0,0 +1,252 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var WebhookSecret = "" // HMAC key for signing forwarded requests, empty leaves them unsigned
+
+var webhookMaxBackoff = time.Minute // longest delay between delivery attempts
+
+// ForwardOptions controls where forward delivers messages and how hard it tries
+type ForwardOptions struct {
+	Webhook     string             // URL each message is POSTed to
+	Secret      string             // HMAC-SHA256 key for the X-Pulse-Signature header, empty = unsigned
+	Template    *template.Template // builds the request body from the message, nil = the message JSON
+	ContentType string             // Content-Type of the request body
+	Retries     int                // attempts after the first failed one
+	Backoff     time.Duration      // delay before the first retry, doubled after each attempt
+	Timeout     time.Duration      // limit for each request
+	DeadLetter  string             // file that messages which could not be delivered are appended to
+	Cursor      string             // resume after the last message delivered under this name
+}
+
+// deadLetter is one line of the dead-letter file
+type deadLetter struct {
+	Message  *Message `json:"message"`
+	Error    string   `json:"error"`
+	Attempts int      `json:"attempts"`
+	FailedAt int64    `json:"failed_at"`
+}
+
+// webhookError is a failed delivery; permanent ones are not retried
+type webhookError struct {
+	err       error
+	permanent bool
+}
+
+func (e *webhookError) Error() string {
+	return e.err.Error()
+}
+
+// ParseWebhookTemplate parses a request body template; "@path" reads it from a file
+// Templates see the message fields (.ID, .Channel, .Author, .CreatedAt, .Kind, .Body, .InReplyTo)
+// and a json function that quotes a value, e.g. {"text": {{json .Body}}}
+func ParseWebhookTemplate(text string) (*template.Template, error) {
+	if path, ok := strings.CutPrefix(text, "@"); ok {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		text = string(data)
+	}
+	return template.New("webhook").Option("missingkey=error").Funcs(template.FuncMap{
+		"json": func(value any) (string, error) {
+			data, err := json.Marshal(value)
+			return string(data), err
+		},
+	}).Parse(text)
+}
+
+// Forward POSTs every new message on the given IDs to a webhook until interrupted
+// Messages are delivered one at a time, in order; one that still fails after every retry
+// goes to the dead-letter file and forwarding moves on
+func Forward(ids []string, opts ForwardOptions, verbose bool) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	ids = uniqueIDs(ids)
+	ctx, cancel := context.WithCancel(ctx)
+	cursor, messages, err := subscribeWithCursor(ctx, ids, opts.Cursor, verbose)
+	if err != nil {
+		cancel()
+		return err
+	}
+	defer closeSubscription(cancel, messages)
+
+	if verbose {
+		fmt.Printf("Forwarding %s to %s\n", strings.Join(ids, ", "), opts.Webhook)
+	}
+
+	client := newWebhookClient(opts.Timeout)
+	for {
+		var msg *Message
+		select {
+		case m, ok := <-messages:
+			if !ok {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return fmt.Errorf("lost connection to all relays")
+			}
+			msg = m
+		case <-ctx.Done():
+			return nil
+		}
+
+		if cursor != nil && cursor.Processed(msg) {
+			continue
+		}
+
+		attempts, err := deliverWebhook(ctx, client, msg, opts, verbose)
+		if ctx.Err() != nil {
+			// Interrupted mid-delivery: the cursor stays put so the next run tries again
+			return nil
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s: %v\n", msg.ID, opts.Webhook, err)
+			if opts.DeadLetter != "" {
+				if err := writeDeadLetter(opts.DeadLetter, msg, err, attempts); err != nil {
+					return fmt.Errorf("dead-letter: %w", err)
+				}
+			}
+		}
+
+		if cursor != nil {
+			if err := cursor.Advance(msg); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// newWebhookClient returns a client with a transport of its own, so webhook requests share
+// neither connections nor settings with whatever else in the process uses http.DefaultTransport
+func newWebhookClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			TLSHandshakeTimeout: 10 * time.Second,
+			IdleConnTimeout:     90 * time.Second,
+			MaxIdleConnsPerHost: 2,
+		},
+	}
+}
+
+// deliverWebhook POSTs a message, retrying with exponential backoff, and returns the attempts made
+func deliverWebhook(ctx context.Context, client *http.Client, msg *Message, opts ForwardOptions, verbose bool) (int, error) {
+	body, err := webhookBody(msg, opts.Template)
+	if err != nil {
+		return 0, fmt.Errorf("template: %w", err)
+	}
+
+	delay := opts.Backoff
+	attempts := 0
+	for {
+		startTime := time.Now()
+		attempts++
+		err := postWebhook(ctx, client, msg, body, opts)
+		if err == nil {
+			if verbose {
+				fmt.Printf("%s: forwarded in %dms\n", msg.ID, time.Since(startTime).Milliseconds())
+			}
+			return attempts, nil
+		}
+
+		var webhookErr *webhookError
+		if attempts > opts.Retries || (errors.As(err, &webhookErr) && webhookErr.permanent) {
+			return attempts, err
+		}
+		if verbose {
+			fmt.Printf("%s: attempt %d failed (%v), retrying in %s\n", msg.ID, attempts, err, delay)
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return attempts, ctx.Err()
+		}
+		delay = min(delay*2, webhookMaxBackoff)
+	}
+}
+
+// postWebhook makes one delivery attempt
+// Client errors other than 408 and 429 would fail again, so they are permanent
+func postWebhook(ctx context.Context, client *http.Client, msg *Message, body []byte, opts ForwardOptions) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, opts.Webhook, bytes.NewReader(body))
+	if err != nil {
+		return &webhookError{err: err, permanent: true}
+	}
+	req.Header.Set("Content-Type", opts.ContentType)
+	req.Header.Set("User-Agent", "pulse-forward")
+	req.Header.Set("X-Pulse-Channel", msg.Channel)
+	req.Header.Set("X-Pulse-Event-Id", msg.ID)
+	if opts.Secret != "" {
+		req.Header.Set("X-Pulse-Signature", SignWebhook(opts.Secret, body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return &webhookError{err: err}
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	permanent := resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests
+	return &webhookError{err: fmt.Errorf("HTTP %s", resp.Status), permanent: permanent}
+}
+
+// webhookBody renders the template for a message, or encodes the message itself
+func webhookBody(msg *Message, tmpl *template.Template) ([]byte, error) {
+	if tmpl == nil {
+		return json.Marshal(msg)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, msg); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SignWebhook returns the X-Pulse-Signature value for a request body: "sha256=" and the hex
+// HMAC-SHA256 of the body under the secret
+func SignWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// writeDeadLetter appends an undeliverable message to the dead-letter file as a JSON line
+func writeDeadLetter(path string, msg *Message, cause error, attempts int) error {
+	line, err := json.Marshal(deadLetter{Message: msg, Error: cause.Error(), Attempts: attempts, FailedAt: time.Now().Unix()})
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// webhookRequest is what a test webhook received
+type webhookRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// newWebhook starts a webhook answering with the given statuses in turn, then 204
+func newWebhook(t *testing.T, statuses ...int) (*httptest.Server, <-chan webhookRequest) {
+	t.Helper()
+	requests := make(chan webhookRequest, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- webhookRequest{header: r.Header.Clone(), body: body}
+		status := http.StatusNoContent
+		if len(statuses) > 0 {
+			status, statuses = statuses[0], statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func TestDeliverWebhook(t *testing.T) {
+	server, requests := newWebhook(t)
+	msg := &Message{ID: "abc123", Channel: "alerts", Author: "f00d", CreatedAt: 1700000000, Kind: KindPulseMessage, Body: "disk full"}
+	opts := ForwardOptions{Webhook: server.URL, Secret: "hook-secret", ContentType: "application/json", Timeout: 5 * time.Second}
+
+	attempts, err := deliverWebhook(context.Background(), newWebhookClient(opts.Timeout), msg, opts, false)
+	if err != nil || attempts != 1 {
+		t.Fatalf("deliverWebhook = %d, %v; want 1 attempt, no error", attempts, err)
+	}
+	req := <-requests
+
+	var got Message
+	if err := json.Unmarshal(req.body, &got); err != nil {
+		t.Fatalf("body is not the message JSON: %v\n%s", err, req.body)
+	}
+	if got != *msg {
+		t.Errorf("body = %+v, want %+v", got, *msg)
+	}
+
+	headers := map[string]string{
+		"Content-Type":     "application/json",
+		"User-Agent":       "pulse-forward",
+		"X-Pulse-Channel":  "alerts",
+		"X-Pulse-Event-Id": "abc123",
+	}
+	for name, want := range headers {
+		if value := req.header.Get(name); value != want {
+			t.Errorf("%s = %q, want %q", name, value, want)
+		}
+	}
+
+	mac := hmac.New(sha256.New, []byte("hook-secret"))
+	mac.Write(req.body)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); req.header.Get("X-Pulse-Signature") != want {
+		t.Errorf("X-Pulse-Signature = %q, want %q", req.header.Get("X-Pulse-Signature"), want)
+	}
+}
+
+func TestDeliverWebhookRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		attempts int
+		fails    bool
+	}{
+		{"server error then success", []int{500, 503}, 3, false},
+		{"rate limited", []int{429}, 2, false},
+		{"client error is permanent", []int{404}, 1, true},
+		{"out of retries", []int{500, 500, 500, 500}, 3, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, _ := newWebhook(t, test.statuses...)
+			opts := ForwardOptions{Webhook: server.URL, ContentType: "application/json", Retries: 2, Backoff: time.Millisecond, Timeout: 5 * time.Second}
+
+			attempts, err := deliverWebhook(context.Background(), newWebhookClient(opts.Timeout), &Message{ID: "abc123"}, opts, false)
+			if attempts != test.attempts || (err != nil) != test.fails {
+				t.Errorf("deliverWebhook = %d, %v; want %d attempts, failure %v", attempts, err, test.attempts, test.fails)
+			}
+		})
+	}
+}
+
+func TestWebhookClientHasItsOwnTransport(t *testing.T) {
+	if transport := newWebhookClient(time.Second).Transport; transport == nil || transport == http.DefaultTransport {
+		t.Error("webhook client uses http.DefaultTransport")
+	}
+}
@@ -0,0 +1,268 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var HTTPToken = "" // bearer token the HTTP gateway requires, generated at startup when empty
+
+var sseKeepAlive = 15 * time.Second // comment sent on idle streams so proxies don't drop them
+
+var httpMaxHistoryLimit = 1000 // largest ?limit= asked of relays, which may cap it lower at their max_limit
+
+// httpGateway serves channels over HTTP for programs that would rather not run the binary
+type httpGateway struct {
+	token   string
+	verbose bool
+	reads   ChannelLocks
+}
+
+// httpLatest is the response to a latest read
+type httpLatest struct {
+	*Message
+	BurnError string `json:"burn_error,omitempty"` // set when a burn-after-read message was shown but not burned
+}
+
+// RunHTTPGateway serves send, history, latest and live streams of channels over HTTP until interrupted
+// Every request must carry the token as "Authorization: Bearer <token>"
+func RunHTTPGateway(addr string, token string, verbose bool) error {
+	if token == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return err
+		}
+		token = hex.EncodeToString(secret)
+		fmt.Printf("Generated token: %s\n", token)
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	enableServerMode(ctx, verbose)
+
+	g := &httpGateway{token: token, verbose: verbose}
+	server := &http.Server{
+		Handler: g.handler(),
+		// Streams end with the gateway instead of holding up its shutdown
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	// Requests already running get a few seconds to finish
+	shutDown := make(chan struct{})
+	go func() {
+		defer close(shutDown)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Printf("Pulse HTTP gateway listening on http://%s\n", listener.Addr())
+	if err := server.Serve(listener); err != http.ErrServerClosed {
+		return err
+	}
+	<-shutDown
+	return nil
+}
+
+// handler routes the gateway's endpoints behind its bearer token
+func (g *httpGateway) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /channels/{id}/messages", g.send)
+	mux.HandleFunc("GET /channels/{id}/messages", g.history)
+	mux.HandleFunc("GET /channels/{id}/latest", g.latest)
+	mux.HandleFunc("GET /channels/{id}/stream", g.stream)
+	return g.authorize(mux)
+}
+
+// authorize rejects requests without the gateway's bearer token
+// Browsers can't set headers on an EventSource, so the token may also come as ?access_token=
+func (g *httpGateway) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		startTime := time.Now()
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			token = r.URL.Query().Get("access_token")
+		}
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(g.token)) != 1 {
+			recorder.Header().Set("WWW-Authenticate", "Bearer")
+			writeHTTPError(recorder, http.StatusUnauthorized, fmt.Errorf("missing or invalid bearer token"))
+		} else {
+			next.ServeHTTP(recorder, r)
+		}
+		if g.verbose {
+			fmt.Printf("%s %s: %d in %dms\n", r.Method, r.URL.Path, recorder.status, time.Since(startTime).Milliseconds())
+		}
+	})
+}
+
+// send publishes the JSON body, which takes the same fields as the daemon's send
+func (g *httpGateway) send(w http.ResponseWriter, r *http.Request) {
+	var params daemonSendParams
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, int64(4*MaxMessageSize))).Decode(&params); err != nil {
+		writeHTTPError(w, http.StatusBadRequest, err)
+		return
+	}
+	params.Channel = r.PathValue("id")
+	opts, err := params.options()
+	if err == nil {
+		err = CurrentSettings().checkSendOptions(params.Channel, opts)
+	}
+	if err != nil {
+		writeHTTPError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ev, err := NewMessageEvent(r.Context(), params.Channel, DeriveKey(params.Channel), params.Message, SigningKey(), opts, false)
+	if err != nil {
+		writeHTTPError(w, http.StatusInternalServerError, err)
+		return
+	}
+	err = PublishEvent(r.Context(), opts.writeRelays(params.Channel), ev, false)
+	switch {
+	case errors.Is(err, ErrNoRelay):
+		writeHTTPError(w, http.StatusBadGateway, err)
+		return
+	case err != nil:
+		writeHTTPError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, daemonSendResult{EventID: ev.ID})
+}
+
+// history lists the newest stored messages of a channel, oldest first, without burning any
+// ?limit= defaults to history-limit and is asked of every relay, up to httpMaxHistoryLimit
+func (g *httpGateway) history(w http.ResponseWriter, r *http.Request) {
+	s := CurrentSettings()
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			writeHTTPError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q", value))
+			return
+		}
+		if n > httpMaxHistoryLimit {
+			writeHTTPError(w, http.StatusBadRequest, fmt.Errorf("limit %d is above the maximum of %d", n, httpMaxHistoryLimit))
+			return
+		}
+		s.HistoryLimit = n
+	}
+
+	messages, err := s.History(r.Context(), r.PathValue("id"), false)
+	if err != nil {
+		writeHTTPError(w, http.StatusBadGateway, err)
+		return
+	}
+	// Each relay returns up to the limit, so together they may return more
+	if len(messages) > s.HistoryLimit {
+		messages = messages[len(messages)-s.HistoryLimit:]
+	}
+	writeJSON(w, http.StatusOK, messages)
+}
+
+// latest reads what get would show, burning one-time messages; ?latest=true reads the
+// channel's replaceable latest value
+func (g *httpGateway) latest(w http.ResponseWriter, r *http.Request) {
+	opts := RetrieveOptions{Latest: r.URL.Query().Get("latest") == "true"}
+	msg, burnErr, err := CurrentSettings().ReadMessage(r.Context(), &g.reads, r.PathValue("id"), opts, false)
+	if errors.Is(err, ErrAlreadyRead) {
+		writeHTTPError(w, http.StatusGone, err)
+		return
+	}
+	if err != nil {
+		writeHTTPError(w, http.StatusNotFound, err)
+		return
+	}
+
+	result := httpLatest{Message: msg}
+	if burnErr != nil {
+		result.BurnError = burnErr.Error()
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// stream sends live messages as Server-Sent Events until the client disconnects
+func (g *httpGateway) stream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeHTTPError(w, http.StatusInternalServerError, fmt.Errorf("streaming is not supported"))
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	messages := SubscribeMessages(ctx, r.PathValue("id"), false)
+	defer closeSubscription(cancel, messages)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": subscribed\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(msg)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "id: %s\nevent: message\ndata: %s\n\n", msg.ID, data)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case <-ctx.Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// statusRecorder remembers the status code written, for the request log
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Flush passes through, so streams reach the client as they are written
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeHTTPError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
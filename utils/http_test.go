@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"pulse/internal/relaytest"
+)
+
+// getHistory requests a channel's history from a gateway with the given query string
+func getHistory(t *testing.T, server *httptest.Server, query string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest("GET", server.URL+"/channels/history-test/messages"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer token")
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestHTTPHistoryRejectsBadLimits(t *testing.T) {
+	server := httptest.NewServer((&httpGateway{token: "token"}).handler())
+	defer server.Close()
+
+	for _, limit := range []string{"0", "-3", "ten", "1.5", "1001"} {
+		if resp := getHistory(t, server, "?limit="+limit); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("?limit=%s: status %d, want 400", limit, resp.StatusCode)
+		}
+	}
+}
+
+func TestHTTPHistoryAsksRelaysForTheLimit(t *testing.T) {
+	relay := relaytest.New(t)
+	useRelays(t, relay.URL)
+	historyLimit := HistoryLimit
+	HistoryLimit = 5
+	t.Cleanup(func() { HistoryLimit = historyLimit })
+	storeMessages(t, "history-test", 10, relay)
+
+	server := httptest.NewServer((&httpGateway{token: "token"}).handler())
+	defer server.Close()
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"", 5},
+		{"?limit=2", 2},
+		{"?limit=8", 8},
+		{"?limit=50", 10},
+	}
+	for _, test := range tests {
+		resp := getHistory(t, server, test.query)
+		var messages []*Message
+		if err := json.NewDecoder(resp.Body).Decode(&messages); err != nil {
+			t.Fatalf("%q: %v", test.query, err)
+		}
+		if len(messages) != test.want {
+			t.Errorf("%q: %d messages, want %d", test.query, len(messages), test.want)
+		}
+	}
+}
+
+func TestHTTPSendStatus(t *testing.T) {
+	relay := relaytest.New(t)
+	dead := deadRelay(t)
+	server := httptest.NewServer((&httpGateway{token: "token"}).handler())
+	defer server.Close()
+
+	tests := []struct {
+		name   string
+		relays []string
+		body   string
+		want   int
+	}{
+		{"sent", []string{relay.URL}, `{"message":"hi"}`, http.StatusCreated},
+		{"not JSON", []string{relay.URL}, `hi`, http.StatusBadRequest},
+		{"bad ttl", []string{relay.URL}, `{"message":"hi","ttl":"soon"}`, http.StatusBadRequest},
+		{"once and latest", []string{relay.URL}, `{"message":"hi","once":true,"latest":true}`, http.StatusBadRequest},
+		{"no relay took it", []string{dead}, `{"message":"hi"}`, http.StatusBadGateway},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useRelays(t, test.relays...)
+			req, err := http.NewRequest("POST", server.URL+"/channels/send-test/messages", strings.NewReader(test.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Authorization", "Bearer token")
+			resp, err := server.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != test.want {
+				t.Errorf("status %d, want %d", resp.StatusCode, test.want)
+			}
+		})
+	}
+}
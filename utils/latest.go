@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// KindPulseLatest is the addressable kind used for "latest value" channels
+const KindPulseLatest = 34242
+
+var LatestKind = KindPulseLatest
+
+// latestIdentity derives the signing key and d tag shared by every writer of a latest-value channel
+// Relays only replace addressable events from the same author, so the author must come from the channel key
+func latestIdentity(key []byte) (sk string, pk string, dTag string) {
+	skHash := sha256.Sum256(append([]byte("pulse-latest-key:"), key...))
+	dHash := sha256.Sum256(append([]byte("pulse-latest-d:"), key...))
+	sk = hex.EncodeToString(skHash[:])
+	pk, _ = nostr.GetPublicKey(sk)
+	return sk, pk, hex.EncodeToString(dHash[:])
+}
+
+// IsLatestChannel reports whether a channel publishes and reads latest-value events
+func (s *Settings) IsLatestChannel(id string, requested bool) bool {
+	return requested || s.ChannelConfig(id).Latest
+}
+
+// latestEvent builds an unsigned addressable event that replaces the channel's previous value on
+// relays, along with the channel-derived key it must be signed with
+func (s *Settings) latestEvent(key []byte, content string) (string, nostr.Event) {
+	sk, _, dTag := latestIdentity(key)
+	return sk, nostr.Event{
+		Kind:    s.LatestKind,
+		Tags:    nostr.Tags{{"d", dTag}, {"t", hex.EncodeToString(key)}},
+		Content: content,
+	}
+}
+
+// FetchLatest looks up the current value of a latest-value channel
+// Each relay is read until EOSE, so the result doesn't depend on a timing window
+func (s *Settings) FetchLatest(ctx context.Context, id string, key []byte, verbose bool) (*nostr.Event, error) {
+	_, pk, dTag := latestIdentity(key)
+	filter := nostr.Filter{
+		Kinds:   []int{s.LatestKind},
+		Authors: []string{pk},
+		Tags:    nostr.TagMap{"d": []string{dTag}},
+	}
+
+	events, err := s.QueryRelays(ctx, s.RelaysForReading(id), filter, verbose)
+	if err != nil {
+		return nil, err
+	}
+
+	var latest *nostr.Event
+	for _, ev := range events {
+		if latest == nil || newerReplaceable(ev, latest) {
+			latest = ev
+		}
+	}
+	return latest, nil
+}
+
+// newerReplaceable applies the NIP-01 rule: newest created_at wins, ties go to the lowest ID
+func newerReplaceable(a *nostr.Event, b *nostr.Event) bool {
+	if a.CreatedAt != b.CreatedAt {
+		return a.CreatedAt > b.CreatedAt
+	}
+	return a.ID < b.ID
+}
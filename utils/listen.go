@@ -3,82 +3,371 @@ package utils
 import (
 	"context"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
-	"sync"
+	"os"
+	"os/signal"
+	"regexp"
+	"slices"
 	"time"
 
 	"github.com/nbd-wtf/go-nostr"
 )
 
+// Message is a decrypted channel message as delivered to listeners
+type Message struct {
+	ID        string `json:"id"`
+	Channel   string `json:"channel"`
+	Author    string `json:"author"`
+	CreatedAt int64  `json:"created_at"`
+	Kind      int    `json:"kind"`
+	Body      string `json:"body"`
+	InReplyTo string `json:"in_reply_to,omitempty"`
+}
+
+// ListenOptions controls how many messages listen waits for and how they are printed
+type ListenOptions struct {
+	Follow  bool          // keep streaming until interrupted (or Count is reached)
+	Count   int           // stop after this many messages, 0 = one, or unlimited with Follow
+	JSON    bool          // print each message as a JSON object instead of its body
+	Timeout time.Duration // give up after this long, 0 = wait indefinitely
+	Cursor  string        // resume after the last message processed under this name
+
+	Match *regexp.Regexp // only deliver messages whose body matches
+	Query *JSONQuery     // only deliver JSON messages the query is true for
+	From  string         // only deliver messages signed by this hex public key
+
+	Exec            string        // command run per message instead of printing it
+	ExecConcurrency int           // commands running at once, at least 1
+	ExecTimeout     time.Duration // kill a command after this long, 0 = no limit
+	ExecReply       bool          // publish each command's stdout as a reply on the channel
+}
+
+// limit returns how many messages to deliver, 0 meaning no limit
+func (o ListenOptions) limit() int {
+	if o.Count == 0 && !o.Follow {
+		return 1
+	}
+	return o.Count
+}
+
+// matches reports whether a message passes every predicate that is set
+func (o ListenOptions) matches(msg *Message) bool {
+	if o.From != "" && msg.Author != o.From {
+		return false
+	}
+	if o.Match != nil && !o.Match.MatchString(msg.Body) {
+		return false
+	}
+	if o.Query != nil && !o.Query.Match(msg.Body) {
+		return false
+	}
+	return true
+}
+
 // ListenForMessage listens for a new message on the given ID and prints it
-// timeout is in seconds, 0 means no timeout
+// timeout is in seconds, 0 means no timeout; an interrupt ends the wait cleanly
 func ListenForMessage(id string, verbose bool, timeoutSeconds int) error {
-	key := DeriveKey(id)
-	hashedTag := hex.EncodeToString(key)
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	sk := nostr.GeneratePrivateKey()
-	pk, _ := nostr.GetPublicKey(sk)
-
-	var messageReceived sync.WaitGroup
-	messageReceived.Add(1)
-	var foundMessage bool
-
-	// Start listening from now
-	for _, url := range Relays {
-		go func(u string) {
-			if foundMessage {
-				return
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ctx, cancel := context.WithCancel(ctx)
+
+	messages := SubscribeMessages(ctx, id, verbose)
+	defer closeSubscription(cancel, messages)
+
+	msg, err := nextMessage(ctx, messages, time.Duration(timeoutSeconds)*time.Second)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	fmt.Print(msg.Body)
+	return nil
+}
+
+// Listen streams new messages on the given IDs, one per line, until the count or timeout is reached
+// With several channels each line is labelled with its channel; an interrupt ends a follow cleanly
+func Listen(ids []string, opts ListenOptions, verbose bool) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	return listen(ctx, ids, opts, verbose)
+}
+
+// listen streams messages until the limit or timeout is reached, or the context is cancelled
+func listen(ctx context.Context, ids []string, opts ListenOptions, verbose bool) error {
+	ids = uniqueIDs(ids)
+	ctx, cancel := context.WithCancel(ctx)
+	cursor, messages, err := subscribeWithCursor(ctx, ids, opts.Cursor, verbose)
+	if err != nil {
+		cancel()
+		return err
+	}
+	defer closeSubscription(cancel, messages)
+	limit := opts.limit()
+
+	var runner *execRunner
+	if opts.Exec != "" {
+		if runner, err = newExecRunner(cursor, opts, verbose); err != nil {
+			return err
+		}
+		// Runs before the context is cancelled, so the last commands get to finish
+		defer runner.Wait()
+	}
+
+	var deadline <-chan time.Time
+	if opts.Timeout > 0 {
+		deadline = time.After(opts.Timeout)
+	}
+
+	for received := 0; limit == 0 || received < limit; {
+		var msg *Message
+		select {
+		case m, ok := <-messages:
+			if !ok {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return fmt.Errorf("lost connection to all relays")
+			}
+			msg = m
+		case <-deadline:
+			if opts.Follow && received > 0 {
+				return nil
 			}
+			return fmt.Errorf("no message received within timeout")
+		case <-ctx.Done():
+			return nil
+		}
 
-			r, err := nostr.RelayConnect(ctx, u)
-			if err != nil {
-				return
+		if cursor != nil && cursor.Processed(msg) {
+			continue
+		}
+
+		// Replies from commands (ours or another listener's) would otherwise trigger more replies
+		skip := !opts.matches(msg) || (runner != nil && opts.ExecReply && msg.InReplyTo != "")
+
+		if runner != nil {
+			if skip {
+				runner.Skip(msg)
+				continue
+			}
+			runner.Run(ctx, msg)
+			received++
+			continue
+		}
+
+		if skip {
+			// Skipped messages are still processed, so a cursor doesn't offer them again
+			if cursor != nil {
+				if err := cursor.Advance(msg); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if err := printMessage(msg, opts.JSON, len(ids) > 1); err != nil {
+			return err
+		}
+		received++
+
+		// Saved only after the message is out, so a crash repeats it rather than losing it
+		if cursor != nil {
+			if err := cursor.Advance(msg); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// uniqueIDs drops repeated channel IDs, keeping the first of each
+func uniqueIDs(ids []string) []string {
+	var unique []string
+	for _, id := range ids {
+		if !slices.Contains(unique, id) {
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+// subscribeWithCursor subscribes to the channels, first delivering what was stored since the
+// named cursor when one is given
+func subscribeWithCursor(ctx context.Context, ids []string, name string, verbose bool) (*Cursor, <-chan *Message, error) {
+	if name == "" {
+		return nil, SubscribeChannels(ctx, ids, verbose), nil
+	}
+	cursor, err := LoadCursor(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cursor, SubscribeFromCursor(ctx, ids, cursor, verbose), nil
+}
+
+// SubscribeMessages streams decrypted messages published on a channel from now on
+// Events are deduplicated across relays; undecryptable and expired ones are dropped
+func SubscribeMessages(ctx context.Context, id string, verbose bool) <-chan *Message {
+	return SubscribeChannels(ctx, []string{id}, verbose)
+}
+
+// SubscribeChannels streams messages from several channels through the daemon when enabled,
+// or else through CurrentSettings().SubscribeChannels
+func SubscribeChannels(ctx context.Context, ids []string, verbose bool) <-chan *Message {
+	if UseDaemon {
+		if messages := subscribeThroughDaemon(ctx, ids, verbose); messages != nil {
+			return messages
+		}
+	}
+	return CurrentSettings().SubscribeChannels(ctx, ids, verbose)
+}
+
+// SubscribeChannels streams decrypted messages from several channels over one subscription per relay
+// Each relay gets a single filter with the tags of every channel read from it, and events are
+// matched back to their channel by tag
+func (s *Settings) SubscribeChannels(ctx context.Context, ids []string, verbose bool) <-chan *Message {
+	channels := make(map[string]string)      // hashed tag -> channel ID
+	keys := make(map[string][]byte)          // channel ID -> key
+	filters := make(map[string]nostr.Filter) // channel ID -> live filter
+	relayChannels := make(map[string][]string)
+	var relays []string
+
+	for _, id := range ids {
+		if _, ok := keys[id]; ok {
+			continue
+		}
+		key := s.DeriveKey(id)
+		keys[id] = key
+		channels[hex.EncodeToString(key)] = id
+		filters[id] = s.LiveFilter(id, key)
+
+		for _, url := range s.RelaysForReading(id) {
+			if _, ok := relayChannels[url]; !ok {
+				relays = append(relays, url)
 			}
+			relayChannels[url] = append(relayChannels[url], id)
+		}
+	}
 
-			now := nostr.Now()
-			sub, _ := r.Subscribe(ctx, []nostr.Filter{{
-				Tags:  nostr.TagMap{"t": []string{hashedTag}},
-				Kinds: []int{nostr.KindTextNote},
-				Since: &now,
-			}})
-
-			for ev := range sub.Events {
-				// Only accept messages from others, not from ourselves
-				if ev.PubKey != pk {
-					msg, err := Decrypt(ev.Content, key)
-					if err == nil {
-						fmt.Print(msg)
-						foundMessage = true
-						messageReceived.Done()
-						cancel()
-						return
-					}
+	// Only channels read from a relay are revealed to it
+	filterFor := func(url string) []nostr.Filter {
+		var merged nostr.Filter
+		for _, id := range relayChannels[url] {
+			filter := filters[id]
+			if merged.Tags == nil {
+				merged = filter
+				merged.Tags = nostr.TagMap{"t": nil}
+				merged.Kinds = nil
+			}
+			merged.Tags["t"] = append(merged.Tags["t"], filter.Tags["t"]...)
+			for _, kind := range filter.Kinds {
+				if !slices.Contains(merged.Kinds, kind) {
+					merged.Kinds = append(merged.Kinds, kind)
 				}
 			}
-		}(url)
+		}
+		return []nostr.Filter{merged}
 	}
 
-	// Wait for a message with timeout
-	done := make(chan struct{})
+	out := make(chan *Message)
+	tracker := s.newStatusTracker(verbose)
+	events := s.subscribeRelays(ctx, relays, filterFor, tracker, verbose)
+
 	go func() {
-		messageReceived.Wait()
-		close(done)
+		defer drainAndClose(events, out)
+		for ev := range events {
+			// Kinds are merged across channels, so check the event against its own channel's filter
+			id := ""
+			for tag := range ev.Tags.FindAll("t") {
+				if channel, ok := channels[tag[1]]; ok {
+					id = channel
+					break
+				}
+			}
+			if id == "" || !slices.Contains(filters[id].Kinds, ev.Kind) {
+				continue
+			}
+			env, err := s.OpenMessage(ev, keys[id])
+			if err != nil {
+				continue
+			}
+			select {
+			case out <- newMessage(id, ev, env):
+			case <-ctx.Done():
+				return
+			}
+		}
 	}()
 
-	// Handle timeout
-	if timeoutSeconds == 0 {
-		// No timeout - wait indefinitely
-		<-done
-		return nil
-	} else {
-		// Wait with timeout
-		select {
-		case <-done:
-			return nil
-		case <-time.After(time.Duration(timeoutSeconds) * time.Second):
-			return fmt.Errorf("no message received within timeout")
+	return out
+}
+
+// closeSubscription cancels a subscription and waits until its relay connections are closed
+func closeSubscription(cancel context.CancelFunc, messages <-chan *Message) {
+	cancel()
+	for range messages {
+	}
+}
+
+// drainAndClose closes a forwarding channel once its source is closed, so that a reader
+// draining it knows every relay connection behind it has been closed
+func drainAndClose[T any](source <-chan T, out chan *Message) {
+	for range source {
+	}
+	close(out)
+}
+
+// newMessage builds the listener view of a decrypted event
+func newMessage(id string, ev *nostr.Event, env *Envelope) *Message {
+	return &Message{
+		ID:        ev.ID,
+		Channel:   id,
+		Author:    ev.PubKey,
+		CreatedAt: int64(ev.CreatedAt),
+		Kind:      ev.Kind,
+		Body:      env.Body,
+		InReplyTo: env.InReplyTo,
+	}
+}
+
+// nextMessage waits for one message, 0 meaning no timeout
+func nextMessage(ctx context.Context, messages <-chan *Message, timeout time.Duration) (*Message, error) {
+	// A nil channel never fires, so no timeout waits indefinitely
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		deadline = time.After(timeout)
+	}
+
+	select {
+	case msg, ok := <-messages:
+		if !ok {
+			return nil, fmt.Errorf("lost connection to all relays")
 		}
+		return msg, nil
+	case <-deadline:
+		return nil, fmt.Errorf("no message received within timeout")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// printMessage writes one message per line, as its body or as JSON
+// JSON always carries the channel; plain bodies get a [channel] label when asked
+func printMessage(msg *Message, asJSON bool, label bool) error {
+	if !asJSON {
+		if label {
+			fmt.Printf("[%s] %s\n", msg.Channel, msg.Body)
+		} else {
+			fmt.Println(msg.Body)
+		}
+		return nil
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
 	}
+	fmt.Println(string(data))
+	return nil
 }
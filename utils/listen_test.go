@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"pulse/internal/relaytest"
+)
+
+// useRelays points the package configuration at the given relays for one test
+func useRelays(t *testing.T, urls ...string) {
+	t.Helper()
+	relays, readRelays, writeRelays, useDaemon := Relays, ReadRelays, WriteRelays, UseDaemon
+	Relays, ReadRelays, WriteRelays, UseDaemon = urls, nil, nil, false
+	t.Cleanup(func() {
+		Relays, ReadRelays, WriteRelays, UseDaemon = relays, readRelays, writeRelays, useDaemon
+	})
+}
+
+// captureStdout collects everything printed until the test ends; output returns it so far
+func captureStdout(t *testing.T) (output func() string) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+
+	var mu sync.Mutex
+	var buf bytes.Buffer
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		chunk := make([]byte, 4096)
+		for {
+			n, err := r.Read(chunk)
+			mu.Lock()
+			buf.Write(chunk[:n])
+			mu.Unlock()
+			if err == io.EOF || err != nil {
+				return
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		os.Stdout = stdout
+		w.Close()
+		<-done
+		r.Close()
+	})
+
+	return func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return buf.String()
+	}
+}
+
+func TestListenDeliversEachEventOnce(t *testing.T) {
+	first, second := relaytest.New(t), relaytest.New(t)
+	useRelays(t, first.URL, second.URL)
+	output := captureStdout(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- listen(ctx, []string{"listen-test"}, ListenOptions{Follow: true}, false)
+	}()
+	relaytest.WaitFor(t, "a subscription on both relays", func() bool {
+		return first.Subscriptions() == 1 && second.Subscriptions() == 1
+	})
+
+	publish := func(body string, relays ...*relaytest.Relay) {
+		t.Helper()
+		ev, err := NewMessageEvent(ctx, "listen-test", DeriveKey("listen-test"), body, SigningKey(), SendOptions{}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, relay := range relays {
+			relay.Publish(&ev)
+		}
+	}
+	publish("same event", first, second)
+	// Each relay delivers in order, so once both markers are out both copies have been handled
+	publish("marker one", first)
+	publish("marker two", second)
+	relaytest.WaitFor(t, "both markers", func() bool {
+		return strings.Contains(output(), "marker one") && strings.Contains(output(), "marker two")
+	})
+
+	// Cancelled mid-stream, with the subscriptions still open
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("listen returned %v after cancellation", err)
+	}
+	// Connections stay pooled for the next command, but nothing is left subscribed
+	relaytest.WaitFor(t, "every subscription to close", func() bool {
+		return first.Subscriptions() == 0 && second.Subscriptions() == 0
+	})
+	if first.OpenConnections() != 1 || second.OpenConnections() != 1 {
+		t.Errorf("%d and %d connections open, want one per relay", first.OpenConnections(), second.OpenConnections())
+	}
+
+	if n := strings.Count(output(), "same event"); n != 1 {
+		t.Errorf("event printed %d times, want once:\n%s", n, output())
+	}
+}
@@ -3,7 +3,6 @@ package utils
 import (
 	"bufio"
 	"context"
-	"encoding/hex"
 	"fmt"
 	"os"
 	"strings"
@@ -14,14 +13,12 @@ import (
 )
 
 // StartChat enters interactive chat mode for the given ID
-func StartChat(id string, username string, verbose bool) error {
+func StartChat(id string, username string, opts SendOptions, verbose bool) error {
 	key := DeriveKey(id)
-	hashedTag := hex.EncodeToString(key)
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	sk := nostr.GeneratePrivateKey()
-	pk, _ := nostr.GetPublicKey(sk)
+	sk := SigningKey()
 
 	// Fetch history first
 	history, err := FetchHistory(ctx, id, key, verbose)
@@ -30,17 +27,28 @@ func StartChat(id string, username string, verbose bool) error {
 	}
 
 	seenEvents := make(map[string]bool)
-
-	// Print history
-	for _, ev := range history {
+	// Keyed by event ID and author, since only a message's own author may retract it and a
+	// read ack only counts when signed with the message's burn key
+	retracted := make(map[string]bool)
+	acked := make(map[string]bool)
+	shown := make(map[string]string) // bodies of the messages on screen that can be retracted
+
+	// Print history, burning one-time messages as get does
+	for _, ev := range history.Messages {
 		if seenEvents[ev.ID] {
 			continue
 		}
 		seenEvents[ev.ID] = true
 
-		msg, err := Decrypt(ev.Content, key)
-		if err == nil {
-			fmt.Println(msg)
+		env, err := OpenMessage(ev, key)
+		if err != nil {
+			continue
+		}
+		fmt.Println(env.Body)
+		if !env.Once() {
+			shown[ev.ID+":"+ev.PubKey] = env.Body
+		} else if err := ConsumeMessage(ctx, id, key, ev, env, verbose); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: message shown but could not be marked as read: %v\n", err)
 		}
 	}
 
@@ -48,33 +56,92 @@ func StartChat(id string, username string, verbose bool) error {
 
 	var listenMu sync.Mutex
 
+	tracker := NewStatusTracker(verbose)
+
+	// Deletion requests and read acks from now on come with the live messages
+	now := nostr.Now()
+	controls := ControlFilter(key)
+	controls.Since = &now
+	controls.Limit = 0
+
 	// Start live listener
-	for _, url := range Relays {
+	for _, url := range RelaysForReading(id) {
+		tracker.AddRelay(url)
 		go func(u string) {
-			r, err := nostr.RelayConnect(ctx, u)
+			r, err := ConnectRelay(ctx, u)
 			if err != nil {
 				return
 			}
 
-			now := nostr.Now()
-			sub, _ := r.Subscribe(ctx, []nostr.Filter{{
-				Tags:  nostr.TagMap{"t": []string{hashedTag}},
-				Kinds: []int{nostr.KindTextNote},
-				Since: &now,
-			}})
+			sub, err := r.Subscribe(ctx, []nostr.Filter{LiveFilter(id, key), controls})
+			if err != nil {
+				return
+			}
 
-			for ev := range sub.Events {
-				listenMu.Lock()
-				if seenEvents[ev.ID] {
+			for {
+				select {
+				case reason := <-sub.ClosedReason:
+					sub, err = ResubscribeAfterClosed(ctx, r, u, sub, reason, tracker)
+					if err != nil {
+						if verbose {
+							fmt.Printf("\r\033[K%s: %s\n> ", u, err)
+						}
+						return
+					}
+					if verbose {
+						fmt.Printf("\r\033[K%s: authenticated\n> ", u)
+					}
+				case ev, ok := <-sub.Events:
+					if !ok {
+						return
+					}
+					listenMu.Lock()
+					if seenEvents[ev.ID] {
+						listenMu.Unlock()
+						continue
+					}
+					seenEvents[ev.ID] = true
+
+					if ev.Kind == nostr.KindDeletion || ev.Kind == KindPulseAck {
+						for _, tag := range ev.Tags {
+							if len(tag) < 2 || tag[0] != "e" {
+								continue
+							}
+							target := tag[1] + ":" + ev.PubKey
+							if ev.Kind == KindPulseAck {
+								acked[target] = true
+								continue
+							}
+							retracted[target] = true
+							if body, ok := shown[target]; ok {
+								delete(shown, target)
+								fmt.Printf("\r\033[K[retracted] %s\n> ", body)
+							}
+						}
+						listenMu.Unlock()
+						continue
+					}
+
+					// Our own messages were marked as seen before publishing
+					env, err := OpenMessage(ev, key)
+					if err != nil || retracted[ev.ID+":"+ev.PubKey] || acked[ev.ID+":"+ev.PubKey] && burnedBy(ev, env, ev.PubKey) {
+						listenMu.Unlock()
+						continue
+					}
+					fmt.Printf("\r\033[K%s\n> ", env.Body)
+					if !env.Once() {
+						shown[ev.ID+":"+ev.PubKey] = env.Body
+					}
 					listenMu.Unlock()
-					continue
-				}
-				seenEvents[ev.ID] = true
-				listenMu.Unlock()
 
-				msg, err := Decrypt(ev.Content, key)
-				if err == nil && ev.PubKey != pk {
-					fmt.Printf("\r\033[K%s\n> ", msg)
+					if env.Once() {
+						// Burned in the background, so this relay's messages keep coming
+						go func() {
+							if err := ConsumeMessage(ctx, id, key, ev, env, verbose); err != nil {
+								fmt.Fprintf(os.Stderr, "\r\033[Kwarning: message shown but could not be marked as read: %v\n> ", err)
+							}
+						}()
+					}
 				}
 			}
 		}(url)
@@ -93,23 +160,22 @@ func StartChat(id string, username string, verbose bool) error {
 		timestamp := time.Now().Format("15:04")
 		formattedMsg := fmt.Sprintf("[%s] %s: %s", timestamp, username, text)
 
-		encrypted, _ := Encrypt(formattedMsg, key)
-		ev := nostr.Event{
-			PubKey:    pk,
-			CreatedAt: nostr.Now(),
-			Kind:      nostr.KindTextNote,
-			Tags:      nostr.Tags{{"t", hashedTag}},
-			Content:   encrypted,
+		ev, err := NewMessageEvent(ctx, id, key, formattedMsg, sk, opts, verbose)
+		if err != nil {
+			fmt.Printf("\033[A\033[KFailed to encrypt message: %s\n", err)
+			continue
 		}
-		ev.Sign(sk)
 
 		listenMu.Lock()
 		seenEvents[ev.ID] = true
+		if !opts.Once {
+			shown[ev.ID+":"+ev.PubKey] = formattedMsg
+		}
 		listenMu.Unlock()
 
 		// Clear line and print our formatted message
 		fmt.Printf("\033[A\033[K%s\n", formattedMsg)
 
-		PublishEvent(ctx, ev, verbose)
+		PublishEvent(ctx, opts.writeRelays(id), ev, verbose)
 	}
 }
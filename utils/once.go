@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// KindPulseAck is the regular kind of read acknowledgments for burn-after-read messages
+const KindPulseAck = 4243
+
+var ErrAlreadyRead = errors.New("message already read")
+
+// burnedBy reports whether an ack signed by author burns a message: only a burn-after-read
+// message signed with the burn key in its envelope can be acknowledged, and only by that key
+func burnedBy(ev *nostr.Event, env *Envelope, author string) bool {
+	if !env.Once() || ev.PubKey != author {
+		return false
+	}
+	pk, err := nostr.GetPublicKey(env.BurnKey)
+	return err == nil && pk == author
+}
+
+// ApplyAcks drops the burn-after-read messages acknowledged with their own burn key and returns
+// the acks that did; acks for any other message, fetched or not, are ignored
+func (s *Settings) ApplyAcks(key []byte, events []*nostr.Event, acks []*nostr.Event) (kept, applied []*nostr.Event) {
+	if len(acks) == 0 {
+		return events, nil
+	}
+
+	acked := make(map[string]*nostr.Event) // message ID:ack author -> ack
+	for _, ack := range acks {
+		for _, tag := range ack.Tags {
+			if len(tag) >= 2 && tag[0] == "e" {
+				acked[tag[1]+":"+ack.PubKey] = ack
+			}
+		}
+	}
+
+	kept = events[:0]
+	for _, ev := range events {
+		if ack := acked[ev.ID+":"+ev.PubKey]; ack != nil {
+			if env, err := s.OpenMessage(ev, key); err == nil && burnedBy(ev, env, ack.PubKey) {
+				applied = append(applied, ack)
+				continue
+			}
+		}
+		kept = append(kept, ev)
+	}
+	return kept, applied
+}
+
+// ConsumeMessage is a wrapper around CurrentSettings().ConsumeMessage
+func ConsumeMessage(ctx context.Context, id string, key []byte, ev *nostr.Event, env *Envelope, verbose bool) error {
+	return CurrentSettings().ConsumeMessage(ctx, id, key, ev, env, verbose)
+}
+
+// ConsumeMessage marks a burn-after-read message as read for other readers
+// It publishes an ack and a NIP-09 deletion, both signed with the burn key from the envelope
+func (s *Settings) ConsumeMessage(ctx context.Context, id string, key []byte, ev *nostr.Event, env *Envelope, verbose bool) error {
+	if !env.Once() {
+		return nil
+	}
+
+	hashedTag := hex.EncodeToString(key)
+	kind := strconv.Itoa(ev.Kind)
+
+	if verbose {
+		fmt.Println("\nBurning message...")
+	}
+
+	ack := nostr.Event{
+		CreatedAt: nostr.Now(),
+		Kind:      KindPulseAck,
+		Tags: nostr.Tags{
+			{"e", ev.ID},
+			{"k", kind},
+			{"t", hashedTag},
+		},
+		Content: "read",
+	}
+	relays := s.RelaysForWriting(id)
+	if err := s.SignEvent(ctx, &ack, env.BurnKey, relays, verbose); err != nil {
+		return err
+	}
+
+	deletion := nostr.Event{
+		CreatedAt: nostr.Now(),
+		Kind:      nostr.KindDeletion,
+		Tags:      nostr.Tags{{"e", ev.ID}, {"k", kind}, {"t", hashedTag}},
+		Content:   "burned after reading",
+	}
+	if err := s.SignEvent(ctx, &deletion, env.BurnKey, relays, verbose); err != nil {
+		return err
+	}
+
+	if err := s.PublishEvent(ctx, relays, ack, verbose); err != nil {
+		return fmt.Errorf("publishing read ack: %w", err)
+	}
+	if err := s.PublishEvent(ctx, relays, deletion, verbose); err != nil {
+		return fmt.Errorf("publishing deletion: %w", err)
+	}
+	return nil
+}
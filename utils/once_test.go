@@ -0,0 +1,153 @@
+package utils
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"pulse/internal/relaytest"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// signedEvent signs an event with the given key
+func signedEvent(t *testing.T, sk string, kind int, createdAt nostr.Timestamp, tags nostr.Tags) *nostr.Event {
+	t.Helper()
+	ev := &nostr.Event{Kind: kind, CreatedAt: createdAt, Tags: tags}
+	if err := ev.Sign(sk); err != nil {
+		t.Fatal(err)
+	}
+	return ev
+}
+
+// messageEvent seals a message for a channel the way send does, dated createdAt
+func messageEvent(t *testing.T, id, body, sk string, opts SendOptions, createdAt nostr.Timestamp) *nostr.Event {
+	t.Helper()
+	ev, err := NewMessageEvent(context.Background(), id, DeriveKey(id), body, sk, opts, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Once messages are signed with their burn key, so re-sign with whichever key was used
+	env, err := OpenMessage(&ev, DeriveKey(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Once() {
+		sk = env.BurnKey
+	}
+	ev.CreatedAt = createdAt
+	if err := ev.Sign(sk); err != nil {
+		t.Fatal(err)
+	}
+	return &ev
+}
+
+// ackEvent is a read ack for a message, signed with the given key
+func ackEvent(t *testing.T, sk string, target string, key []byte) *nostr.Event {
+	t.Helper()
+	return signedEvent(t, sk, KindPulseAck, nostr.Now(), nostr.Tags{{"e", target}, {"t", hex.EncodeToString(key)}})
+}
+
+func TestApplyAcks(t *testing.T) {
+	useRelays(t)
+	const id = "ack-test"
+	key := DeriveKey(id)
+	author := nostr.GeneratePrivateKey()
+	once := messageEvent(t, id, "secret", author, SendOptions{Once: true}, nostr.Now()-10)
+	plain := messageEvent(t, id, "plain", author, SendOptions{}, nostr.Now()-5)
+	burnKey := func() string {
+		env, err := OpenMessage(once, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return env.BurnKey
+	}()
+
+	tests := []struct {
+		name    string
+		ack     *nostr.Event
+		burned  string // ID of the message the ack should burn, empty = none
+		applied int
+	}{
+		{"ack by the burn key", ackEvent(t, burnKey, once.ID, key), once.ID, 1},
+		{"ack by another key", ackEvent(t, nostr.GeneratePrivateKey(), once.ID, key), "", 0},
+		{"ack of a plain message by its author", ackEvent(t, author, plain.ID, key), "", 0},
+		{"ack of a message that wasn't fetched", ackEvent(t, burnKey, nostr.GeneratePrivateKey(), key), "", 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			kept, applied := CurrentSettings().ApplyAcks(key, []*nostr.Event{once, plain}, []*nostr.Event{test.ack})
+			if len(applied) != test.applied {
+				t.Errorf("%d acks applied, want %d", len(applied), test.applied)
+			}
+			for _, ev := range kept {
+				if ev.ID == test.burned {
+					t.Errorf("burned message %s was kept", ev.ID)
+				}
+			}
+			if want := 2 - test.applied; len(kept) != want {
+				t.Errorf("%d messages kept, want %d", len(kept), want)
+			}
+		})
+	}
+}
+
+func TestGetIgnoresForgedAcks(t *testing.T) {
+	relay := relaytest.New(t)
+	useRelays(t, relay.URL)
+
+	const id = "forged-ack-test"
+	key := DeriveKey(id)
+	plain := messageEvent(t, id, "plain", nostr.GeneratePrivateKey(), SendOptions{}, nostr.Now()-5)
+	relay.Publish(plain)
+
+	// A throwaway key acks and deletes an event that never existed
+	throwaway := nostr.GeneratePrivateKey()
+	madeUp := nostr.GeneratePrivateKey() // any 64 hex digits will do
+	relay.Publish(ackEvent(t, throwaway, madeUp, key))
+	relay.Publish(signedEvent(t, throwaway, nostr.KindDeletion, nostr.Now(), nostr.Tags{{"e", madeUp}, {"t", hex.EncodeToString(key)}}))
+
+	env, _, err := CurrentSettings().findMessage(context.Background(), id, key, RetrieveOptions{}, false)
+	if err != nil {
+		t.Fatalf("get after a forged ack: %v", err)
+	}
+	if env.Body != "plain" {
+		t.Errorf("get = %q, want plain", env.Body)
+	}
+}
+
+func TestGetAfterOlderMessageIsBurned(t *testing.T) {
+	relay := relaytest.New(t)
+	useRelays(t, relay.URL)
+
+	const id = "burned-older-test"
+	key := DeriveKey(id)
+	once := messageEvent(t, id, "secret", nostr.GeneratePrivateKey(), SendOptions{Once: true}, nostr.Now()-10)
+	plain := messageEvent(t, id, "plain", nostr.GeneratePrivateKey(), SendOptions{}, nostr.Now()-5)
+	relay.Publish(once)
+	relay.Publish(plain)
+
+	// A later reader, chat for instance, burns the older one-time message
+	env, err := OpenMessage(once, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ConsumeMessage(context.Background(), id, key, once, env, false); err != nil {
+		t.Fatal(err)
+	}
+
+	latest, _, err := CurrentSettings().findMessage(context.Background(), id, key, RetrieveOptions{}, false)
+	if err != nil {
+		t.Fatalf("get after burning an older message: %v", err)
+	}
+	if latest.Body != "plain" {
+		t.Errorf("get = %q, want plain", latest.Body)
+	}
+
+	// With only the burned message left, get reports it as read
+	relay.Remove(plain.ID)
+	if _, _, err := CurrentSettings().findMessage(context.Background(), id, key, RetrieveOptions{}, false); !errors.Is(err, ErrAlreadyRead) {
+		t.Errorf("get with only a burned message: err = %v, want ErrAlreadyRead", err)
+	}
+}
@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+var OutboxRetries = 6                  // publish attempts per relay after the first one fails
+var OutboxBackoff = 5 * time.Second    // delay before the first retry, doubled after each attempt
+var OutboxMaxBackoff = 5 * time.Minute // longest delay between retries
+
+// relayOutbox republishes events to relays that couldn't be reached when they were sent
+type relayOutbox struct {
+	ctx     context.Context
+	verbose bool
+
+	mu      sync.Mutex
+	pending map[string]bool // event ID + relay being retried
+}
+
+var cliOutbox *relayOutbox // set while running as the daemon, nil for one-shot commands
+
+// enableOutbox makes PublishEvent queue failed relays for retries until the context ends
+func enableOutbox(ctx context.Context, verbose bool) {
+	cliOutbox = &relayOutbox{ctx: ctx, verbose: verbose, pending: make(map[string]bool)}
+}
+
+// Add schedules retries of an event for a relay that failed to take it
+// A relay that answered and refused the event would refuse it again, so only
+// connection and transport failures are retried
+func (o *relayOutbox) Add(url string, event nostr.Event, err error) {
+	if strings.HasPrefix(err.Error(), "msg: ") {
+		return
+	}
+
+	entry := event.ID + " " + url
+	o.mu.Lock()
+	if o.pending[entry] {
+		o.mu.Unlock()
+		return
+	}
+	o.pending[entry] = true
+	o.mu.Unlock()
+
+	go o.retry(url, event, entry)
+}
+
+// retry publishes the event with exponential backoff until it is accepted or refused
+func (o *relayOutbox) retry(url string, event nostr.Event, entry string) {
+	defer func() {
+		o.mu.Lock()
+		delete(o.pending, entry)
+		o.mu.Unlock()
+	}()
+
+	delay := OutboxBackoff
+	for attempt := 1; attempt <= OutboxRetries; attempt++ {
+		select {
+		case <-time.After(delay):
+		case <-o.ctx.Done():
+			return
+		}
+		delay = min(delay*2, OutboxMaxBackoff)
+
+		ctx, cancel := context.WithTimeout(o.ctx, 10*time.Second)
+		r, err := ConnectRelay(ctx, url)
+		if err == nil {
+			err = PublishWithAuth(ctx, r, url, event, NewStatusTracker(false))
+		}
+		cancel()
+
+		if err == nil {
+			if o.verbose {
+				fmt.Printf("outbox: %s published to %s after %d retries\n", event.ID, url, attempt)
+			}
+			return
+		}
+		if strings.HasPrefix(err.Error(), "msg: ") {
+			fmt.Fprintf(os.Stderr, "outbox: %s refused by %s: %v\n", event.ID, url, err)
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "outbox: giving up on %s for %s after %d retries\n", event.ID, url, OutboxRetries)
+}
+
+// Pending returns how many relay deliveries are waiting for a retry
+func (o *relayOutbox) Pending() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.pending)
+}
@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// relayPool keeps one connection per relay open for as long as the settings it belongs to
+// Connections are reused rather than closed after each operation: go-nostr v0.52.3 races with
+// itself whenever a connection goes down, so subscriptions end with a CLOSE instead
+type relayPool struct {
+	mu      sync.Mutex
+	relays  map[string]*nostr.Relay
+	dialing map[string]*sync.Mutex // one dial per relay at a time, so none is left over
+}
+
+var cliRelays = &relayPool{}
+
+// relayConnections returns the pool the relay connections of these settings come from
+func (s *Settings) relayConnections() *relayPool {
+	if s.connections == nil {
+		return cliRelays
+	}
+	return s.connections
+}
+
+// connect returns the pooled connection to a relay, reconnecting if it dropped
+// The connection outlives the caller's context, which only bounds the dial
+func (p *relayPool) connect(ctx context.Context, url string) (*nostr.Relay, error) {
+	url = nostr.NormalizeURL(url)
+
+	p.mu.Lock()
+	if p.relays == nil {
+		p.relays = make(map[string]*nostr.Relay)
+		p.dialing = make(map[string]*sync.Mutex)
+	}
+	dial := p.dialing[url]
+	if dial == nil {
+		dial = &sync.Mutex{}
+		p.dialing[url] = dial
+	}
+	p.mu.Unlock()
+
+	dial.Lock()
+	defer dial.Unlock()
+
+	p.mu.Lock()
+	r := p.relays[url]
+	p.mu.Unlock()
+	if r != nil && r.IsConnected() {
+		return r, nil
+	}
+
+	r = nostr.NewRelay(context.Background(), url)
+	if err := r.Connect(ctx); err != nil {
+		return nil, err
+	}
+	if err := checkProxied(ctx); err != nil {
+		r.Close()
+		return nil, fmt.Errorf("%s: %w", url, err)
+	}
+	p.mu.Lock()
+	p.relays[url] = r
+	p.mu.Unlock()
+	return r, nil
+}
+
+// connected returns the relays the pool currently holds open connections to
+func (p *relayPool) connected() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var urls []string
+	for url, r := range p.relays {
+		if r.IsConnected() {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
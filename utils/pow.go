@@ -0,0 +1,178 @@
+package utils
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"math"
+	"math/bits"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip13"
+)
+
+var PowDifficulty = 0     // NIP-13 proof-of-work for every published event, 0 = only what relays require
+var MaxPowDifficulty = 28 // highest difficulty mined for a relay's advertised NIP-11 requirement
+
+// ParsePowDifficulty validates a NIP-13 difficulty in leading zero bits
+func ParsePowDifficulty(value string) (int, error) {
+	difficulty, err := strconv.Atoi(value)
+	if err != nil || difficulty < 0 || difficulty > 256 {
+		return 0, fmt.Errorf("invalid difficulty %q, expected 0-256 leading zero bits", value)
+	}
+	return difficulty, nil
+}
+
+// RequiredDifficulty returns the proof-of-work needed to publish to all of the given relays
+// Configured difficulties always apply; advertised ones only up to MaxPowDifficulty
+func (s *Settings) RequiredDifficulty(ctx context.Context, relays []string) int {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	required := s.Pow
+
+	for _, url := range relays {
+		wg.Add(1)
+		go func(u string) {
+			defer wg.Done()
+			difficulty := s.RelayConfig(u).Pow
+
+			// Relays asking for more than we're willing to mine are skipped at publish time
+			if info, _ := s.GetRelayInfo(ctx, u); info != nil && info.Limitation != nil {
+				if advertised := info.Limitation.MinPowDifficulty; advertised <= s.MaxPow && advertised > difficulty {
+					difficulty = advertised
+				}
+			}
+
+			mu.Lock()
+			if difficulty > required {
+				required = difficulty
+			}
+			mu.Unlock()
+		}(url)
+	}
+	wg.Wait()
+
+	return required
+}
+
+// SignEvent is a wrapper around CurrentSettings().SignEvent
+func SignEvent(ctx context.Context, ev *nostr.Event, sk string, relays []string, verbose bool) error {
+	return CurrentSettings().SignEvent(ctx, ev, sk, relays, verbose)
+}
+
+// SignEvent mines the proof-of-work the relays require, then signs the event
+func (s *Settings) SignEvent(ctx context.Context, ev *nostr.Event, sk string, relays []string, verbose bool) error {
+	difficulty := s.RequiredDifficulty(ctx, relays)
+	if difficulty > 0 {
+		pk, err := nostr.GetPublicKey(sk)
+		if err != nil {
+			return err
+		}
+		ev.PubKey = pk
+		if err := MineEvent(ctx, ev, difficulty, verbose); err != nil {
+			return err
+		}
+	}
+	return ev.Sign(sk)
+}
+
+// MineEvent adds a NIP-13 nonce tag giving the event ID the target difficulty
+// The work is split across all CPU cores and stops when the context is cancelled
+func MineEvent(ctx context.Context, ev *nostr.Event, difficulty int, verbose bool) error {
+	if ev.PubKey == "" {
+		return nip13.ErrMissingPubKey
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	startTime := time.Now()
+	var hashes atomic.Uint64
+	found := make(chan nostr.Tag, 1)
+	workers := runtime.NumCPU()
+
+	for i := 0; i < workers; i++ {
+		// Each worker needs its own copy of the tags, since it rewrites the nonce in place
+		work := *ev
+		work.Tags = append(append(nostr.Tags{}, ev.Tags...), nostr.Tag{"nonce", "", strconv.Itoa(difficulty)})
+		nonceTag := work.Tags[len(work.Tags)-1]
+
+		go func(nonce uint64) {
+			for {
+				// Check for cancellation in batches to keep hashing fast
+				for n := 0; n < 10000; n++ {
+					nonceTag[1] = strconv.FormatUint(nonce, 10)
+					id := sha256.Sum256(work.Serialize())
+					if leadingZeroBits(id) >= difficulty {
+						select {
+						case found <- nonceTag:
+						default:
+						}
+						cancel()
+						return
+					}
+					nonce += uint64(workers)
+				}
+				hashes.Add(10000)
+
+				select {
+				case <-ctx.Done():
+					return
+				default:
+				}
+			}
+		}(uint64(i))
+	}
+
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+
+	// The winning worker cancels the context after handing over its nonce
+	for {
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+			if verbose {
+				// Each hash succeeds with probability 2^-difficulty, so the expected count is only a guide
+				done := hashes.Load()
+				fmt.Printf("\r\033[KMining proof-of-work %d on %d cores: %d of ~%.0f expected hashes (%.0f/s)",
+					difficulty, workers, done, math.Pow(2, float64(difficulty)), float64(done)/time.Since(startTime).Seconds())
+			}
+			continue
+		}
+		break
+	}
+
+	select {
+	case tag := <-found:
+		ev.Tags = append(ev.Tags, tag)
+		if verbose {
+			fmt.Printf("\r\033[KMined proof-of-work %d in %dms\n", difficulty, time.Since(startTime).Milliseconds())
+		}
+		return nil
+	default:
+		if verbose {
+			fmt.Print("\r\033[K")
+		}
+		return fmt.Errorf("proof-of-work cancelled: %w", ctx.Err())
+	}
+}
+
+// leadingZeroBits counts the leading zero bits of an event ID
+func leadingZeroBits(id [32]byte) int {
+	zeros := 0
+	for _, b := range id {
+		if b == 0 {
+			zeros += 8
+			continue
+		}
+		zeros += bits.LeadingZeros8(b)
+		break
+	}
+	return zeros
+}
@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip13"
+)
+
+// powRelay returns the URL of a relay whose NIP-11 document asks for a proof-of-work difficulty
+func powRelay(t *testing.T, difficulty int) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"name":"pow","limitation":{"min_pow_difficulty":%d}}`, difficulty)
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func TestMineEventMeetsTheTarget(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	ev := &nostr.Event{Kind: KindPulseMessage, CreatedAt: nostr.Now(), PubKey: pk, Content: "mined"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := MineEvent(ctx, ev, 12, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := ev.Sign(sk); err != nil {
+		t.Fatal(err)
+	}
+
+	if difficulty := nip13.Difficulty(ev.ID); difficulty < 12 {
+		t.Errorf("mined event has difficulty %d, want at least 12", difficulty)
+	}
+	nonce := ev.Tags.Find("nonce")
+	if len(nonce) != 3 || nonce[2] != "12" {
+		t.Errorf("nonce tag %v doesn't commit to difficulty 12", nonce)
+	}
+}
+
+func TestMineEventStopsWhenCancelled(t *testing.T) {
+	pk, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	ev := &nostr.Event{Kind: KindPulseMessage, CreatedAt: nostr.Now(), PubKey: pk}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := MineEvent(ctx, ev, 200, false); err == nil {
+		t.Fatal("mined difficulty 200")
+	}
+	if len(ev.Tags) != 0 {
+		t.Errorf("cancelled mining left tags %v", ev.Tags)
+	}
+}
+
+func TestRequiredDifficulty(t *testing.T) {
+	modest, greedy := powRelay(t, 8), powRelay(t, 30)
+
+	tests := []struct {
+		name     string
+		relays   []string
+		pow      int // global pow
+		relayPow int // pow for the modest relay
+		maxPow   int
+		want     int
+	}{
+		{"advertised", []string{modest}, 0, 0, 28, 8},
+		{"highest of the relays", []string{modest, greedy}, 0, 0, 30, 30},
+		{"above max-pow", []string{modest, greedy}, 0, 0, 28, 8},
+		{"max-pow 0 mines nothing for relays", []string{modest, greedy}, 0, 0, 0, 0},
+		{"max-pow 0 keeps the configured pow", []string{modest}, 4, 0, 0, 4},
+		{"configured above advertised", []string{modest}, 0, 10, 28, 10},
+		{"global above advertised", []string{modest}, 16, 0, 28, 16},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := DefaultSettings()
+			s.Pow, s.MaxPow = test.pow, test.maxPow
+			if test.relayPow > 0 {
+				s.RelayConfigs[nostr.NormalizeURL(modest)] = &RelayConfig{Pow: test.relayPow}
+			}
+			if got := s.RequiredDifficulty(context.Background(), test.relays); got != test.want {
+				t.Errorf("RequiredDifficulty = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+var Proxy = "" // global proxy for relay connections, e.g. socks5://127.0.0.1:9050
+
+// ProxyDirect in a relay section bypasses the global proxy for that relay
+const ProxyDirect = "direct"
+
+// ParseProxy validates a proxy setting and returns it normalized
+func ParseProxy(value string) (string, error) {
+	if value == ProxyDirect {
+		return value, nil
+	}
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("invalid proxy URL %q", value)
+	}
+	switch u.Scheme {
+	case "socks5", "socks5h", "http", "https":
+		return value, nil
+	}
+	return "", fmt.Errorf("unsupported proxy scheme %q, use socks5, http or https", u.Scheme)
+}
+
+// ProxyForRelay returns the proxy URL to use for a relay, or "" for a direct connection
+func (s *Settings) ProxyForRelay(relayURL string) string {
+	proxy := s.RelayConfig(relayURL).Proxy
+	if proxy == "" {
+		proxy = s.Proxy
+	}
+	if proxy == ProxyDirect {
+		return ""
+	}
+	return proxy
+}
+
+// relayTransports holds one HTTP transport per proxy, used only for relay traffic so that other
+// HTTP clients in the process, such as webhook deliveries, never go through a relay's proxy
+type relayTransports struct {
+	mu         sync.Mutex
+	transports map[string]*http.Transport
+}
+
+var cliTransports = &relayTransports{}
+
+// proxyTransports returns the transports relay traffic of these settings goes through
+func (s *Settings) proxyTransports() *relayTransports {
+	if s.transports == nil {
+		return cliTransports
+	}
+	return s.transports
+}
+
+// get returns the transport for a proxy URL; "" connects directly, honoring HTTP_PROXY and friends
+func (t *relayTransports) get(proxy string) (*http.Transport, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if transport, ok := t.transports[proxy]; ok {
+		return transport, nil
+	}
+
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		TLSHandshakeTimeout: 10 * time.Second,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	if proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL %q", proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if t.transports == nil {
+		t.transports = make(map[string]*http.Transport)
+	}
+	t.transports[proxy] = transport
+	return transport, nil
+}
+
+// relayHTTPClient returns the client for HTTP requests to a relay, such as NIP-11 fetches
+func (s *Settings) relayHTTPClient(relayURL string) (*http.Client, error) {
+	transport, err := s.proxyTransports().get(s.ProxyForRelay(relayURL))
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// relayDialKey marks a context whose WebSocket dial goes through a relay's proxy transport
+type relayDialKey struct{}
+
+// proxiedDial is the transport a relay's WebSocket dial must go through, and whether it did
+type proxiedDial struct {
+	transport http.RoundTripper
+	used      atomic.Bool
+}
+
+// relayDialContext returns a context that makes a relay's WebSocket dial use its proxy
+// It fails rather than let the dial go direct when the proxy can't be applied
+func (s *Settings) relayDialContext(ctx context.Context, relayURL string) (context.Context, error) {
+	proxy := s.ProxyForRelay(relayURL)
+	if proxy == "" {
+		return ctx, nil
+	}
+	transport, err := s.proxyTransports().get(proxy)
+	if err != nil {
+		return nil, err
+	}
+	installRelayRouter()
+	if _, ok := http.DefaultClient.Transport.(*relayRouter); !ok {
+		return nil, fmt.Errorf("proxy %s not applied: http.DefaultClient has been replaced", proxy)
+	}
+	return context.WithValue(ctx, relayDialKey{}, &proxiedDial{transport: transport}), nil
+}
+
+// checkProxied fails a dial that relayDialContext marked for a proxy but that never reached it
+func checkProxied(ctx context.Context) error {
+	if dial, ok := ctx.Value(relayDialKey{}).(*proxiedDial); ok && !dial.used.Load() {
+		return fmt.Errorf("connection bypassed the configured proxy")
+	}
+	return nil
+}
+
+// relayRouter sends relay dials marked by relayDialContext through their proxy transport
+// and every other request where it would have gone anyway
+type relayRouter struct {
+	next http.RoundTripper // nil means http.DefaultTransport
+}
+
+func (r *relayRouter) RoundTrip(req *http.Request) (*http.Response, error) {
+	if dial, ok := req.Context().Value(relayDialKey{}).(*proxiedDial); ok {
+		dial.used.Store(true)
+		return dial.transport.RoundTrip(req)
+	}
+	if r.next != nil {
+		return r.next.RoundTrip(req)
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// installRelayRouter hooks relayRouter into http.DefaultClient the first time a relay is dialed
+// through a proxy. go-nostr dials WebSockets with that client and takes no other, so the hook
+// is shared by the whole process; it only changes requests carrying a relayDialKey
+var installRelayRouter = sync.OnceFunc(func() {
+	http.DefaultClient.Transport = &relayRouter{next: http.DefaultClient.Transport}
+})
+
+// isOnionHost reports whether a host is a Tor hidden service
+func isOnionHost(host string) bool {
+	return strings.HasSuffix(strings.ToLower(host), ".onion")
+}
+
+// checkOnionProxy fails early for .onion relays that would otherwise be dialled directly
+func (s *Settings) checkOnionProxy(relayURL string) error {
+	u, err := url.Parse(nostr.NormalizeURL(relayURL))
+	if err != nil {
+		return err
+	}
+	if isOnionHost(u.Hostname()) && s.ProxyForRelay(relayURL) == "" {
+		return fmt.Errorf("%s is a .onion relay, set proxy = socks5://127.0.0.1:9050", relayURL)
+	}
+	return nil
+}
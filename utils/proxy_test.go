@@ -0,0 +1,225 @@
+package utils
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"pulse/internal/relaytest"
+)
+
+// socksStandIn is a minimal SOCKS5 proxy (no auth, CONNECT only) that sends every connection
+// to one backend, whatever host was asked for, and records the hosts
+type socksStandIn struct {
+	listener net.Listener
+	backend  string
+
+	mu      sync.Mutex
+	targets []string
+}
+
+func newSocksStandIn(t *testing.T, backend string) *socksStandIn {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &socksStandIn{listener: listener, backend: backend}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go p.handle(conn)
+		}
+	}()
+	return p
+}
+
+func (p *socksStandIn) URL() string {
+	return "socks5://" + p.listener.Addr().String()
+}
+
+func (p *socksStandIn) handle(conn net.Conn) {
+	defer conn.Close()
+
+	// Greeting: version, method count, methods; answer "no authentication"
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil || header[0] != 5 {
+		return
+	}
+	if _, err := io.ReadFull(conn, make([]byte, header[1])); err != nil {
+		return
+	}
+	conn.Write([]byte{5, 0})
+
+	// Request: version, CONNECT, reserved, address type, address, port
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil || request[1] != 1 {
+		return
+	}
+	var host string
+	switch request[3] {
+	case 1:
+		addr := make([]byte, 4)
+		io.ReadFull(conn, addr)
+		host = net.IP(addr).String()
+	case 3:
+		length := make([]byte, 1)
+		io.ReadFull(conn, length)
+		name := make([]byte, length[0])
+		io.ReadFull(conn, name)
+		host = string(name)
+	default:
+		return
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return
+	}
+	p.mu.Lock()
+	p.targets = append(p.targets, net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+	p.mu.Unlock()
+
+	backend, err := net.Dial("tcp", p.backend)
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer backend.Close()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+
+	go io.Copy(backend, conn)
+	io.Copy(conn, backend)
+}
+
+func (p *socksStandIn) connections() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.targets...)
+}
+
+func TestProxyRoutesRelayTraffic(t *testing.T) {
+	relay := relaytest.New(t)
+	proxy := newSocksStandIn(t, strings.TrimPrefix(relay.URL, "ws://"))
+
+	// The stand-in resolves the .onion name, so it must never be looked up locally
+	const onion = "ws://pulsetestrelayxyz.onion"
+	s := DefaultSettings()
+	s.Proxy = proxy.URL()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	info, err := s.fetchRelayInfo(ctx, onion)
+	if err != nil {
+		t.Fatalf("NIP-11 through the proxy: %v", err)
+	}
+	if info.Name != "fake" {
+		t.Fatalf("NIP-11 name = %q, want fake", info.Name)
+	}
+
+	if _, err := s.ConnectRelay(ctx, onion); err != nil {
+		t.Fatalf("WebSocket through the proxy: %v", err)
+	}
+
+	targets := proxy.connections()
+	if len(targets) != 2 {
+		t.Fatalf("proxy saw %d connections, want 2: %v", len(targets), targets)
+	}
+	for _, target := range targets {
+		if target != "pulsetestrelayxyz.onion:80" {
+			t.Errorf("proxy was asked for %s, want pulsetestrelayxyz.onion:80", target)
+		}
+	}
+}
+
+func TestProxyLeavesOtherTrafficAlone(t *testing.T) {
+	relay := relaytest.New(t)
+	proxy := newSocksStandIn(t, strings.TrimPrefix(relay.URL, "ws://"))
+
+	s := DefaultSettings()
+	s.Proxy = proxy.URL()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := s.ConnectRelay(ctx, relay.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	// A webhook or any other HTTP client in the process goes direct, even through http.DefaultClient
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer webhook.Close()
+	for _, client := range []*http.Client{http.DefaultClient, {}} {
+		resp, err := client.Get(webhook.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	if targets := proxy.connections(); len(targets) != 1 {
+		t.Fatalf("proxy saw %v, want only the relay dial", targets)
+	}
+}
+
+func TestProxyFailsClosed(t *testing.T) {
+	relay := relaytest.New(t)
+	proxy := newSocksStandIn(t, strings.TrimPrefix(relay.URL, "ws://"))
+
+	// Something else in the process takes over http.DefaultClient after the router went in
+	installRelayRouter()
+	router := http.DefaultClient.Transport
+	http.DefaultClient.Transport = &http.Transport{}
+	t.Cleanup(func() { http.DefaultClient.Transport = router })
+
+	s := DefaultSettings()
+	s.Proxy = proxy.URL()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := s.ConnectRelay(ctx, relay.URL); err == nil || !strings.Contains(err.Error(), "proxy") {
+		t.Fatalf("dialing with the router gone: err = %v, want a proxy error", err)
+	}
+	if n := relay.OpenConnections(); n != 0 {
+		t.Errorf("relay saw %d connections, want none", n)
+	}
+}
+
+func TestOnionRelayNeedsProxy(t *testing.T) {
+	s := DefaultSettings()
+	_, err := s.ConnectRelay(context.Background(), "ws://pulsetestrelayxyz.onion")
+	if err == nil || !strings.Contains(err.Error(), "proxy") {
+		t.Fatalf("dialing a .onion relay without a proxy: err = %v", err)
+	}
+}
+
+func TestRelayProxySetting(t *testing.T) {
+	s := DefaultSettings()
+	s.Proxy = "socks5://127.0.0.1:9050"
+	s.RelayConfigs["wss://direct.example.com"] = &RelayConfig{Proxy: ProxyDirect}
+	s.RelayConfigs["wss://other.example.com"] = &RelayConfig{Proxy: "http://127.0.0.1:8080"}
+
+	tests := []struct {
+		relay string
+		want  string
+	}{
+		{"wss://relay.example.com", "socks5://127.0.0.1:9050"},
+		{"wss://direct.example.com", ""},
+		{"other.example.com", "http://127.0.0.1:8080"},
+	}
+	for _, test := range tests {
+		if got := s.ProxyForRelay(test.relay); got != test.want {
+			t.Errorf("ProxyForRelay(%q) = %q, want %q", test.relay, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// JSONQuery is a compiled predicate over JSON message bodies, in a small subset of jq syntax:
+// paths (.status, .build.id, .items[0], .["a key"]), string, number, true, false and null
+// literals, the comparisons == != < <= > >=, and/or, and parentheses
+type JSONQuery struct {
+	source string
+	root   queryNode
+}
+
+type queryNode interface {
+	eval(doc any) any
+}
+
+type pathNode []any // object keys (string) and array indexes (int)
+
+type literalNode struct{ value any }
+
+type compareNode struct {
+	op          string
+	left, right queryNode
+}
+
+type logicNode struct {
+	op          string // "and" or "or"
+	left, right queryNode
+}
+
+// ParseJSONQuery compiles a jq-style predicate
+func ParseJSONQuery(expr string) (*JSONQuery, error) {
+	tokens, err := tokenizeQuery(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query %q: %w", expr, err)
+	}
+	p := &queryParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid query %q: %w", expr, err)
+	}
+	return &JSONQuery{source: expr, root: root}, nil
+}
+
+// Match reports whether a body is JSON and the query is true for it
+// As in jq, everything except false and null counts as true
+func (q *JSONQuery) Match(body string) bool {
+	var doc any
+	if err := json.Unmarshal([]byte(body), &doc); err != nil {
+		return false
+	}
+	return truthy(q.root.eval(doc))
+}
+
+func (q *JSONQuery) String() string {
+	return q.source
+}
+
+func truthy(value any) bool {
+	return value != nil && value != false
+}
+
+func (p pathNode) eval(doc any) any {
+	// Missing keys and indexes into the wrong type give null, as they do in jq
+	for _, step := range p {
+		switch key := step.(type) {
+		case string:
+			object, ok := doc.(map[string]any)
+			if !ok {
+				return nil
+			}
+			doc = object[key]
+		case int:
+			array, ok := doc.([]any)
+			if !ok {
+				return nil
+			}
+			if key < 0 {
+				key += len(array)
+			}
+			if key < 0 || key >= len(array) {
+				return nil
+			}
+			doc = array[key]
+		}
+	}
+	return doc
+}
+
+func (l literalNode) eval(any) any {
+	return l.value
+}
+
+func (c compareNode) eval(doc any) any {
+	left, right := c.left.eval(doc), c.right.eval(doc)
+	switch c.op {
+	case "==":
+		return reflect.DeepEqual(left, right)
+	case "!=":
+		return !reflect.DeepEqual(left, right)
+	}
+
+	// Ordering is only defined between two numbers or two strings
+	var cmp int
+	switch l := left.(type) {
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			return false
+		}
+		cmp = compareOrdered(l, r)
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return false
+		}
+		cmp = strings.Compare(l, r)
+	default:
+		return false
+	}
+
+	switch c.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+func compareOrdered(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func (l logicNode) eval(doc any) any {
+	if l.op == "and" {
+		return truthy(l.left.eval(doc)) && truthy(l.right.eval(doc))
+	}
+	return truthy(l.left.eval(doc)) || truthy(l.right.eval(doc))
+}
+
+// tokenizeQuery splits an expression into paths, literals, operators, keywords and parentheses
+// Paths are kept whole, e.g. `.items[0].name`, and parsed later
+func tokenizeQuery(expr string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, string(c))
+			i++
+		case c == '"':
+			end, err := stringEnd(expr, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, expr[i:end])
+			i = end
+		case c == '.':
+			start := i
+			for i < len(expr) && !strings.ContainsRune(" \t\n()=!<>", rune(expr[i])) {
+				if expr[i] == '"' {
+					end, err := stringEnd(expr, i)
+					if err != nil {
+						return nil, err
+					}
+					i = end
+					continue
+				}
+				i++
+			}
+			tokens = append(tokens, expr[start:i])
+		case strings.ContainsRune("=!<>", rune(c)):
+			op := string(c)
+			if i+1 < len(expr) && expr[i+1] == '=' {
+				op += "="
+			}
+			if op == "=" || op == "!" {
+				return nil, fmt.Errorf("unknown operator %q", op)
+			}
+			tokens = append(tokens, op)
+			i += len(op)
+		default:
+			start := i
+			for i < len(expr) && (unicode.IsLetter(rune(expr[i])) || unicode.IsDigit(rune(expr[i])) || strings.ContainsRune("-+.eE_", rune(expr[i]))) {
+				i++
+			}
+			if i == start {
+				return nil, fmt.Errorf("unexpected %q", c)
+			}
+			tokens = append(tokens, expr[start:i])
+		}
+	}
+	return tokens, nil
+}
+
+// stringEnd returns the index just past the JSON string starting at expr[start]
+func stringEnd(expr string, start int) (int, error) {
+	for i := start + 1; i < len(expr); i++ {
+		switch expr[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1, nil
+		}
+	}
+	return 0, fmt.Errorf("unterminated string")
+}
+
+type queryParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *queryParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *queryParser) parseOr() (queryNode, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.peek() == "or" {
+		p.pos++
+		var right queryNode
+		if right, err = p.parseAnd(); err == nil {
+			left = logicNode{op: "or", left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *queryParser) parseAnd() (queryNode, error) {
+	left, err := p.parseComparison()
+	for err == nil && p.peek() == "and" {
+		p.pos++
+		var right queryNode
+		if right, err = p.parseComparison(); err == nil {
+			left = logicNode{op: "and", left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *queryParser) parseComparison() (queryNode, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	switch op := p.peek(); op {
+	case "==", "!=", "<", "<=", ">", ">=":
+		p.pos++
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return compareNode{op: op, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseOperand() (queryNode, error) {
+	token := p.peek()
+	p.pos++
+
+	switch {
+	case token == "":
+		return nil, fmt.Errorf("unexpected end of query")
+	case token == "(":
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return node, nil
+	case strings.HasPrefix(token, "."):
+		return parsePath(token)
+	}
+
+	// Literals use JSON syntax, which also makes numbers float64 like decoded bodies
+	var value any
+	if err := json.Unmarshal([]byte(token), &value); err != nil {
+		return nil, fmt.Errorf("unexpected %q", token)
+	}
+	return literalNode{value: value}, nil
+}
+
+// parsePath parses `.`, `.a.b`, `.a[0]` and `.["a key"]` into keys and indexes
+func parsePath(token string) (pathNode, error) {
+	path := pathNode{}
+	rest := token[1:]
+	for rest != "" {
+		switch {
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if strings.HasPrefix(rest, `["`) {
+				stop, err := stringEnd(rest, 1)
+				if err != nil {
+					return nil, err
+				}
+				end = stop
+				if end >= len(rest) || rest[end] != ']' {
+					return nil, fmt.Errorf("invalid path %q", token)
+				}
+				key, err := strconv.Unquote(rest[1:end])
+				if err != nil {
+					return nil, fmt.Errorf("invalid path %q", token)
+				}
+				path = append(path, key)
+			} else {
+				if end < 0 {
+					return nil, fmt.Errorf("invalid path %q", token)
+				}
+				index, err := strconv.Atoi(rest[1:end])
+				if err != nil {
+					return nil, fmt.Errorf("invalid index in %q", token)
+				}
+				path = append(path, index)
+			}
+			rest = rest[end+1:]
+		case rest[0] == '.':
+			rest = rest[1:]
+			if rest == "" || rest[0] == '.' {
+				return nil, fmt.Errorf("invalid path %q", token)
+			}
+		default:
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			path = append(path, rest[:end])
+			rest = rest[end:]
+		}
+	}
+	return path, nil
+}
@@ -0,0 +1,144 @@
+package utils
+
+import "testing"
+
+func TestJSONQueryMatch(t *testing.T) {
+	const doc = `{
+		"status": "failed",
+		"code": 502,
+		"ok": false,
+		"missing": null,
+		"build": {"id": "b-17", "attempt": 2},
+		"items": [{"name": "first"}, {"name": "second"}, {"name": "last"}],
+		"a key": "spaced",
+		"a.b": "dotted",
+		"quote\"d": 1,
+		"version": "10"
+	}`
+
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		// Paths
+		{`.status == "failed"`, true},
+		{`.build.id == "b-17"`, true},
+		{`.build.attempt >= 2`, true},
+		{`.items[0].name == "first"`, true},
+		{`.items[1].name == "second"`, true},
+		{`.build`, true},
+		{`.`, true},
+		{`.nothere`, false},
+		{`.nothere == null`, true},
+		{`.build.nothere.deeper == null`, true},
+		{`.status.inner == null`, true},
+		{`.items.name == null`, true},
+		{`.build[0] == null`, true},
+
+		// Quoted keys
+		{`.["a key"] == "spaced"`, true},
+		{`.["a.b"] == "dotted"`, true},
+		{`.["quote\"d"] == 1`, true},
+		{`.["a key"]`, true},
+		{`.["absent key"]`, false},
+
+		// Negative indexes count from the end
+		{`.items[-1].name == "last"`, true},
+		{`.items[-3].name == "first"`, true},
+		{`.items[-4] == null`, true},
+		{`.items[3] == null`, true},
+
+		// Truthiness: only false and null are false
+		{`.ok`, false},
+		{`.missing`, false},
+		{`.code`, true},
+		{`0`, true},
+		{`""`, true},
+
+		// Mixed-type comparisons: equality is by type and value, ordering only within a type
+		{`.code == "502"`, false},
+		{`.code != "502"`, true},
+		{`.version == 10`, false},
+		{`.version > 9`, false},
+		{`.version < 9`, false},
+		{`.version > "9"`, false}, // strings order by bytes, "10" < "9"
+		{`.version < "9"`, true},
+		{`.code > "1"`, false},
+		{`.ok == false`, true},
+		{`.ok == null`, false},
+		{`.missing == null`, true},
+		{`.missing < 1`, false},
+		{`.code == 502.0`, true},
+		{`.code < 1e3`, true},
+
+		// Precedence: and binds tighter than or
+		{`.status == "ok" and .code == 502 or true`, true},
+		{`.status == "ok" and (.code == 502 or true)`, false},
+		{`true or false and false`, true},
+		{`(true or false) and false`, false},
+		{`.code == 502 and .build.attempt == 2 and .items[0].name == "first"`, true},
+		{`.code == 502 and .build.attempt == 3`, false},
+		{`.ok or .missing or .code == 1`, false},
+		{`((.code == 502))`, true},
+	}
+	for _, test := range tests {
+		q, err := ParseJSONQuery(test.query)
+		if err != nil {
+			t.Errorf("ParseJSONQuery(%s): %v", test.query, err)
+			continue
+		}
+		if got := q.Match(doc); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.query, got, test.want)
+		}
+	}
+}
+
+func TestJSONQueryNonJSONBody(t *testing.T) {
+	q, err := ParseJSONQuery(`.status == "ok"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{"", "status ok", `{"status": "ok"`, "[1, 2"} {
+		if q.Match(body) {
+			t.Errorf("%q matched", body)
+		}
+	}
+	if q, _ := ParseJSONQuery(`. == 42`); !q.Match("42") {
+		t.Error("a bare number body should be queryable")
+	}
+}
+
+func TestParseJSONQueryErrors(t *testing.T) {
+	for _, query := range []string{
+		``,
+		`   `,
+		`.status =`,
+		`.status = "ok"`,
+		`.status ! "ok"`,
+		`.status ==`,
+		`== "ok"`,
+		`.status == "ok`,
+		`.["unterminated`,
+		`.["a key"`,
+		`.items[`,
+		`.items[x]`,
+		`.items[1.5]`,
+		`.a..b`,
+		`.a.`,
+		`(.status == "ok"`,
+		`.status == "ok")`,
+		`.status == "ok" and`,
+		`or .status`,
+		`.status "ok"`,
+		`.status == ok`,
+		`.status == "ok" xor true`,
+		`.code == 5 5`,
+		`.status & .code`,
+		`.status == 'ok'`,
+		`.build == {"id": "b-17"}`, // only scalar literals
+	} {
+		if q, err := ParseJSONQuery(query); err == nil {
+			t.Errorf("ParseJSONQuery(%s) = %v, want an error", query, q.root)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// RelayConfig holds settings that apply to a single relay
+type RelayConfig struct {
+	AuthKey string
+	Proxy   string
+	Pow     int // minimum NIP-13 difficulty, on top of what the relay advertises
+}
+
+var AuthKey = "" // default NIP-42 identity key, used for relays without their own
+
+var RelaySettings = map[string]*RelayConfig{}
+
+// RelayConfig returns the settings for a relay, or empty settings if none are configured
+func (s *Settings) RelayConfig(url string) *RelayConfig {
+	if rc, ok := s.RelayConfigs[nostr.NormalizeURL(url)]; ok {
+		return rc
+	}
+	return &RelayConfig{}
+}
+
+// ConnectRelay is a wrapper around CurrentSettings().ConnectRelay
+func ConnectRelay(ctx context.Context, url string) (*nostr.Relay, error) {
+	return CurrentSettings().ConnectRelay(ctx, url)
+}
+
+// ConnectRelay returns the open connection to a relay, dialing it through its configured proxy,
+// if any, the first time; connections stay open for the life of the settings
+func (s *Settings) ConnectRelay(ctx context.Context, url string) (*nostr.Relay, error) {
+	if err := s.checkOnionProxy(url); err != nil {
+		return nil, err
+	}
+	ctx, err := s.relayDialContext(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return s.relayConnections().connect(ctx, url)
+}
+
+// relayAuthKey returns the hex secret key used to authenticate to a relay, if any
+func (s *Settings) relayAuthKey(url string) string {
+	if key := s.RelayConfig(url).AuthKey; key != "" {
+		return key
+	}
+	if s.AuthKey != "" {
+		return s.AuthKey
+	}
+	return s.IdentityKey
+}
+
+// ParseSecretKey accepts a hex or nsec-encoded secret key and returns it as hex
+func ParseSecretKey(value string) (string, error) {
+	if strings.HasPrefix(value, "nsec") {
+		prefix, decoded, err := nip19.Decode(value)
+		if err != nil || prefix != "nsec" {
+			return "", fmt.Errorf("invalid nsec key")
+		}
+		return decoded.(string), nil
+	}
+	if _, err := nostr.GetPublicKey(value); err != nil || len(value) != 64 {
+		return "", fmt.Errorf("invalid secret key, expected 64 hex characters or nsec")
+	}
+	return value, nil
+}
+
+// ParsePublicKey accepts a hex or npub-encoded public key and returns it as hex
+func ParsePublicKey(value string) (string, error) {
+	if strings.HasPrefix(value, "npub") {
+		prefix, decoded, err := nip19.Decode(value)
+		if err != nil || prefix != "npub" {
+			return "", fmt.Errorf("invalid npub key")
+		}
+		return decoded.(string), nil
+	}
+	value = strings.ToLower(value)
+	if !nostr.IsValidPublicKey(value) {
+		return "", fmt.Errorf("invalid public key, expected 64 hex characters or npub")
+	}
+	return value, nil
+}
+
+// IsAuthRequired reports whether a relay error or CLOSED reason asks for NIP-42 authentication
+func IsAuthRequired(reason string) bool {
+	return strings.Contains(reason, "auth-required:")
+}
+
+// Authenticate answers the relay's NIP-42 challenge with the identity configured for it
+func (s *Settings) Authenticate(ctx context.Context, r *nostr.Relay, url string, tracker *StatusTracker) error {
+	key := s.relayAuthKey(url)
+	if key == "" {
+		tracker.SetAuthState(url, "required, no key")
+		return fmt.Errorf("relay requires authentication but no auth-key is configured")
+	}
+
+	err := r.Auth(ctx, func(ev *nostr.Event) error {
+		return ev.Sign(key)
+	})
+	if err != nil {
+		tracker.SetAuthState(url, "failed")
+		return fmt.Errorf("authentication failed: %w", err)
+	}
+
+	tracker.SetAuthState(url, "authenticated")
+	return nil
+}
+
+// PublishWithAuth is a wrapper around CurrentSettings().PublishWithAuth
+func PublishWithAuth(ctx context.Context, r *nostr.Relay, url string, event nostr.Event, tracker *StatusTracker) error {
+	return CurrentSettings().PublishWithAuth(ctx, r, url, event, tracker)
+}
+
+// PublishWithAuth publishes an event, authenticating and retrying once if the relay demands it
+func (s *Settings) PublishWithAuth(ctx context.Context, r *nostr.Relay, url string, event nostr.Event, tracker *StatusTracker) error {
+	err := r.Publish(ctx, event)
+	if err == nil || !IsAuthRequired(err.Error()) {
+		return err
+	}
+
+	if err := s.Authenticate(ctx, r, url, tracker); err != nil {
+		return err
+	}
+	return r.Publish(ctx, event)
+}
+
+// ResubscribeAfterClosed is a wrapper around CurrentSettings().ResubscribeAfterClosed
+func ResubscribeAfterClosed(ctx context.Context, r *nostr.Relay, url string, sub *nostr.Subscription, reason string, tracker *StatusTracker) (*nostr.Subscription, error) {
+	return CurrentSettings().ResubscribeAfterClosed(ctx, r, url, sub, reason, tracker)
+}
+
+// ResubscribeAfterClosed handles a CLOSED message on a subscription
+// If the relay asked for authentication it authenticates and returns a replacement subscription
+func (s *Settings) ResubscribeAfterClosed(ctx context.Context, r *nostr.Relay, url string, sub *nostr.Subscription, reason string, tracker *StatusTracker) (*nostr.Subscription, error) {
+	if !IsAuthRequired(reason) {
+		return nil, fmt.Errorf("subscription closed: %s", reason)
+	}
+
+	if err := s.Authenticate(ctx, r, url, tracker); err != nil {
+		return nil, err
+	}
+	return r.Subscribe(ctx, sub.Filters)
+}
@@ -0,0 +1,228 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip11"
+	"github.com/nbd-wtf/go-nostr/nip13"
+)
+
+var RelayInfoCacheTTL = time.Hour         // how long a fetched NIP-11 document is reused
+var RelayInfoFailureTTL = 5 * time.Minute // how long a relay without a usable document isn't asked again
+
+// relayInfoEntry is a cached NIP-11 document; Info is nil when the relay has none
+type relayInfoEntry struct {
+	Info      *nip11.RelayInformationDocument `json:"info"`
+	FetchedAt time.Time                       `json:"fetched_at"`
+	Failed    bool                            `json:"failed,omitempty"` // the fetch failed, so it is retried sooner
+}
+
+// fresh reports whether a cached entry can still be used
+func (e *relayInfoEntry) fresh() bool {
+	ttl := RelayInfoCacheTTL
+	if e.Failed {
+		ttl = RelayInfoFailureTTL
+	}
+	return time.Since(e.FetchedAt) < ttl
+}
+
+// relayInfoCache holds the NIP-11 documents fetched for one set of settings
+type relayInfoCache struct {
+	mu      sync.Mutex
+	entries map[string]*relayInfoEntry // by normalized URL
+	loaded  map[string]bool            // data directories whose cache file has been read
+}
+
+var cliRelayInfo = &relayInfoCache{}
+
+// relayInfos returns the cache the NIP-11 documents of these settings are kept in
+func (s *Settings) relayInfos() *relayInfoCache {
+	if s.relayInfo == nil {
+		return cliRelayInfo
+	}
+	return s.relayInfo
+}
+
+// GetRelayInfo is a wrapper around CurrentSettings().GetRelayInfo
+func GetRelayInfo(ctx context.Context, url string) (*nip11.RelayInformationDocument, error) {
+	return CurrentSettings().GetRelayInfo(ctx, url)
+}
+
+// GetRelayInfo returns the NIP-11 document for a relay, using the cache when it is fresh
+// A nil document with a nil error means the relay does not publish NIP-11 information
+func (s *Settings) GetRelayInfo(ctx context.Context, url string) (*nip11.RelayInformationDocument, error) {
+	url = nostr.NormalizeURL(url)
+
+	cache := s.relayInfos()
+	cache.mu.Lock()
+	cache.load(s.DataDir)
+	entry, ok := cache.entries[url]
+	cache.mu.Unlock()
+
+	if ok && entry.fresh() {
+		return entry.Info, nil
+	}
+
+	return s.RefreshRelayInfo(ctx, url)
+}
+
+// RefreshRelayInfo is a wrapper around CurrentSettings().RefreshRelayInfo
+func RefreshRelayInfo(ctx context.Context, url string) (*nip11.RelayInformationDocument, error) {
+	return CurrentSettings().RefreshRelayInfo(ctx, url)
+}
+
+// RefreshRelayInfo fetches a relay's NIP-11 document and stores it in the cache
+func (s *Settings) RefreshRelayInfo(ctx context.Context, url string) (*nip11.RelayInformationDocument, error) {
+	url = nostr.NormalizeURL(url)
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	entry := &relayInfoEntry{FetchedAt: time.Now()}
+	info, err := s.fetchRelayInfo(ctx, url)
+	if err == nil {
+		entry.Info = &info
+	} else if ctx.Err() != nil {
+		// Timeouts are not cached so a slow relay is asked again next time
+		return nil, err
+	} else {
+		entry.Failed = true
+	}
+
+	cache := s.relayInfos()
+	cache.mu.Lock()
+	cache.load(s.DataDir)
+	cache.entries[url] = entry
+	cache.save(s.DataDir)
+	cache.mu.Unlock()
+
+	return entry.Info, err
+}
+
+// fetchRelayInfo requests a relay's NIP-11 document over the relay's own transport, so it goes
+// through the relay's proxy like its WebSocket connection does
+func (s *Settings) fetchRelayInfo(ctx context.Context, url string) (nip11.RelayInformationDocument, error) {
+	info := nip11.RelayInformationDocument{URL: url}
+	client, err := s.relayHTTPClient(url)
+	if err != nil {
+		return info, err
+	}
+
+	// ws:// and wss:// become http:// and https://
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http"+strings.TrimPrefix(url, "ws"), nil)
+	if err != nil {
+		return info, err
+	}
+	req.Header.Set("Accept", "application/nostr+json")
+	req.Header.Set("User-Agent", "pulse")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return info, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&info); err != nil {
+		return info, fmt.Errorf("invalid json: %w", err)
+	}
+	info.URL = url
+	return info, nil
+}
+
+// load reads a data directory's cache file once per cache; callers hold mu
+// An empty directory keeps the cache in memory only
+func (c *relayInfoCache) load(dir string) {
+	if c.entries == nil {
+		c.entries = make(map[string]*relayInfoEntry)
+		c.loaded = make(map[string]bool)
+	}
+	if dir == "" || c.loaded[dir] {
+		return
+	}
+	c.loaded[dir] = true
+
+	data, err := os.ReadFile(filepath.Join(dir, "relay-info.json"))
+	if err != nil {
+		return
+	}
+	var cached map[string]*relayInfoEntry
+	if json.Unmarshal(data, &cached) != nil {
+		return
+	}
+	for url, entry := range cached {
+		if current, ok := c.entries[url]; !ok || current.FetchedAt.Before(entry.FetchedAt) {
+			c.entries[url] = entry
+		}
+	}
+}
+
+// save writes the cache to a data directory; callers hold mu
+func (c *relayInfoCache) save(dir string) {
+	if dir == "" {
+		return
+	}
+	data, err := json.MarshalIndent(c.entries, "", "  ")
+	if err != nil {
+		return
+	}
+	os.WriteFile(filepath.Join(dir, "relay-info.json"), data, 0600)
+}
+
+// CheckEventLimits is a wrapper around CurrentSettings().CheckEventLimits
+func CheckEventLimits(url string, info *nip11.RelayInformationDocument, ev nostr.Event) error {
+	return CurrentSettings().CheckEventLimits(url, info, ev)
+}
+
+// CheckEventLimits reports why a relay would reject an event according to its NIP-11 limits
+// It returns nil when the event fits or the relay advertises no limits
+// The URL is passed in because documents don't carry it once they have been cached
+func (s *Settings) CheckEventLimits(url string, info *nip11.RelayInformationDocument, ev nostr.Event) error {
+	if info == nil || info.Limitation == nil {
+		return nil
+	}
+	limits := info.Limitation
+
+	// Paid and whitelisted relays admit their members by NIP-42 identity
+	if limits.PaymentRequired && s.relayAuthKey(url) == "" {
+		return fmt.Errorf("relay requires payment but no auth-key is configured")
+	}
+	if limits.AuthRequired && s.relayAuthKey(url) == "" {
+		return fmt.Errorf("relay requires authentication but no auth-key is configured")
+	}
+	if limits.MaxContentLength > 0 && len(ev.Content) > limits.MaxContentLength {
+		return fmt.Errorf("content is %d bytes, relay allows %d", len(ev.Content), limits.MaxContentLength)
+	}
+	if limits.MaxEventTags > 0 && len(ev.Tags) > limits.MaxEventTags {
+		return fmt.Errorf("event has %d tags, relay allows %d", len(ev.Tags), limits.MaxEventTags)
+	}
+	if limits.MaxMessageLength > 0 {
+		envelope, _ := nostr.EventEnvelope{Event: ev}.MarshalJSON()
+		if len(envelope) > limits.MaxMessageLength {
+			return fmt.Errorf("message is %d bytes, relay allows %d", len(envelope), limits.MaxMessageLength)
+		}
+	}
+	if limits.MinPowDifficulty > 0 {
+		if difficulty := nip13.Difficulty(ev.ID); difficulty < limits.MinPowDifficulty {
+			return fmt.Errorf("event has proof-of-work %d, relay requires %d", difficulty, limits.MinPowDifficulty)
+		}
+	}
+	now := nostr.Now()
+	if limits.CreatedAtLowerLimit > 0 && int64(now-ev.CreatedAt) > limits.CreatedAtLowerLimit {
+		return fmt.Errorf("event is older than the relay accepts")
+	}
+	if limits.CreatedAtUpperLimit > 0 && int64(ev.CreatedAt-now) > limits.CreatedAtUpperLimit {
+		return fmt.Errorf("event is further in the future than the relay accepts")
+	}
+
+	return nil
+}
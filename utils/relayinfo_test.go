@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip11"
+	"github.com/nbd-wtf/go-nostr/nip13"
+)
+
+func TestCheckEventLimits(t *testing.T) {
+	const url = "wss://limits.example.com"
+	ev := nostr.Event{
+		Kind:      KindPulseMessage,
+		CreatedAt: nostr.Now(),
+		Content:   strings.Repeat("x", 100),
+		Tags:      nostr.Tags{{"t", "a"}, {"t", "b"}, {"t", "c"}},
+	}
+	if err := ev.Sign(nostr.GeneratePrivateKey()); err != nil {
+		t.Fatal(err)
+	}
+	difficulty := nip13.Difficulty(ev.ID)
+
+	tests := []struct {
+		name   string
+		limits *nip11.RelayLimitationDocument
+		want   string // part of the error, empty = accepted
+	}{
+		{"no limits", nil, ""},
+		{"content within the limit", &nip11.RelayLimitationDocument{MaxContentLength: 100}, ""},
+		{"content over the limit", &nip11.RelayLimitationDocument{MaxContentLength: 99}, "content is 100 bytes, relay allows 99"},
+		{"tags within the limit", &nip11.RelayLimitationDocument{MaxEventTags: 3}, ""},
+		{"too many tags", &nip11.RelayLimitationDocument{MaxEventTags: 2}, "event has 3 tags, relay allows 2"},
+		{"message over the limit", &nip11.RelayLimitationDocument{MaxMessageLength: 100}, "relay allows 100"},
+		{"enough proof-of-work", &nip11.RelayLimitationDocument{MinPowDifficulty: difficulty}, ""},
+		{"too little proof-of-work", &nip11.RelayLimitationDocument{MinPowDifficulty: difficulty + 1}, "relay requires"},
+		{"auth without a key", &nip11.RelayLimitationDocument{AuthRequired: true}, "no auth-key"},
+		{"payment without a key", &nip11.RelayLimitationDocument{PaymentRequired: true}, "requires payment"},
+		{"dated within the window", &nip11.RelayLimitationDocument{CreatedAtLowerLimit: 60, CreatedAtUpperLimit: 60}, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := DefaultSettings()
+			err := s.CheckEventLimits(url, &nip11.RelayInformationDocument{Limitation: test.limits}, ev)
+			switch {
+			case test.want == "" && err != nil:
+				t.Errorf("rejected: %v", err)
+			case test.want != "" && (err == nil || !strings.Contains(err.Error(), test.want)):
+				t.Errorf("err = %v, want %q", err, test.want)
+			}
+		})
+	}
+
+	// Dated an hour away from now, the same event falls outside one-minute windows
+	for _, offset := range []nostr.Timestamp{-3600, 3600} {
+		dated := ev
+		dated.CreatedAt += offset
+		limits := &nip11.RelayLimitationDocument{CreatedAtLowerLimit: 60, CreatedAtUpperLimit: 60}
+		if err := DefaultSettings().CheckEventLimits(url, &nip11.RelayInformationDocument{Limitation: limits}, dated); err == nil {
+			t.Errorf("event dated %+ds accepted by a one-minute window", offset)
+		}
+	}
+
+	// A configured auth key satisfies auth and payment requirements
+	s := DefaultSettings()
+	s.RelayConfigs[url] = &RelayConfig{AuthKey: nostr.GeneratePrivateKey()}
+	limits := &nip11.RelayLimitationDocument{AuthRequired: true, PaymentRequired: true}
+	if err := s.CheckEventLimits(url, &nip11.RelayInformationDocument{Limitation: limits}, ev); err != nil {
+		t.Errorf("rejected with an auth key configured: %v", err)
+	}
+}
+
+func TestRelayInfoFailuresAreRetried(t *testing.T) {
+	var requests atomic.Int32
+	var broken atomic.Bool
+	broken.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if broken.Load() {
+			w.Write([]byte("not json"))
+			return
+		}
+		w.Write([]byte(`{"name":"fixed"}`))
+	}))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	ttl := RelayInfoFailureTTL
+	t.Cleanup(func() { RelayInfoFailureTTL = ttl })
+	RelayInfoFailureTTL = time.Hour
+
+	s := DefaultSettings()
+	ctx := context.Background()
+	if info, err := s.GetRelayInfo(ctx, url); err == nil || info != nil {
+		t.Fatalf("broken document: info = %v, err = %v", info, err)
+	}
+	broken.Store(false)
+	if info, _ := s.GetRelayInfo(ctx, url); info != nil || requests.Load() != 1 {
+		t.Fatalf("failure not cached: info = %v after %d requests", info, requests.Load())
+	}
+
+	// Once the failure is stale the relay is asked again, and its document kept
+	RelayInfoFailureTTL = 0
+	for range 2 {
+		info, err := s.GetRelayInfo(ctx, url)
+		if err != nil || info == nil || info.Name != "fixed" {
+			t.Fatalf("after the failure expired: info = %v, err = %v", info, err)
+		}
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("%d requests, want 2", n)
+	}
+}
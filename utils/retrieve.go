@@ -2,43 +2,120 @@ package utils
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"strings"
-	"time"
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
 )
 
-// RetrieveMessage gets the most recent message from the given ID
-func RetrieveMessage(id string, verbose bool) error {
-	startTime := time.Now()
-	key := DeriveKey(id)
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+// RetrieveOptions controls how a message is looked up
+type RetrieveOptions struct {
+	Latest bool // read the channel's replaceable latest value instead of racing history
+}
+
+var ErrNoMessages = errors.New("no messages found")
 
-	if verbose {
-		fmt.Println("Retrieving message...")
+// findMessage looks up the message get shows: the latest value, or the newest unexpired message
+func (s *Settings) findMessage(ctx context.Context, id string, key []byte, opts RetrieveOptions, verbose bool) (*Envelope, *nostr.Event, error) {
+	if s.IsLatestChannel(id, opts.Latest) {
+		latest, err := s.FetchLatest(ctx, id, key, verbose)
+		if err != nil {
+			return nil, nil, err
+		}
+		if latest == nil {
+			return nil, nil, fmt.Errorf("no value found")
+		}
+		env, err := s.OpenMessage(latest, key)
+		if err == ErrMessageExpired {
+			return nil, nil, fmt.Errorf("value expired")
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		return env, latest, nil
 	}
 
-	history, err := FetchHistory(ctx, id, key, verbose)
+	history, err := s.FetchHistory(ctx, id, key, verbose)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	if len(history) == 0 {
-		return fmt.Errorf("no messages found")
+	messages := history.Messages
+	if len(messages) == 0 {
+		if len(history.Acks) > 0 {
+			return nil, nil, ErrAlreadyRead
+		}
+		return nil, nil, ErrNoMessages
 	}
 
-	// Get the most recent message (sorted by CreatedAt in ascending order, so last is newest)
-	mostRecent := history[len(history)-1]
-	msg, err := Decrypt(mostRecent.Content, key)
-	if err != nil {
-		return err
+	// Get the most recent message that hasn't expired (history is sorted oldest to newest)
+	expired := 0
+	for i := len(messages) - 1; i >= 0; i-- {
+		env, err := s.OpenMessage(messages[i], key)
+		if err == ErrMessageExpired {
+			expired++
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		return env, messages[i], nil
+	}
+	return nil, nil, fmt.Errorf("%w (%d expired)", ErrNoMessages, expired)
+}
+
+// ChannelLocks serializes reads of each channel, so two concurrent reads in one process
+// can't both show a one-time message
+type ChannelLocks struct {
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func (l *ChannelLocks) channel(id string) *sync.Mutex {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.locks == nil {
+		l.locks = make(map[string]*sync.Mutex)
 	}
+	if l.locks[id] == nil {
+		l.locks[id] = &sync.Mutex{}
+	}
+	return l.locks[id]
+}
 
-	fmt.Print(strings.TrimRight(msg, "\n"))
+// ReadMessage finds the message get would show and burns it if it is a one-time message
+// A failed burn is reported separately, since the message has been read either way
+func (s *Settings) ReadMessage(ctx context.Context, locks *ChannelLocks, id string, opts RetrieveOptions, verbose bool) (msg *Message, burnErr error, err error) {
+	lock := locks.channel(id)
+	lock.Lock()
+	defer lock.Unlock()
 
-	if verbose {
-		fmt.Printf("\nTotal operation time: %dms\n", time.Since(startTime).Milliseconds())
+	key := s.DeriveKey(id)
+	env, ev, err := s.findMessage(ctx, id, key, opts, verbose)
+	if err != nil {
+		return nil, nil, err
 	}
+	if env.Once() {
+		burnErr = s.ConsumeMessage(ctx, id, key, ev, env, verbose)
+	}
+	return newMessage(id, ev, env), burnErr, nil
+}
 
-	return nil
+// History returns the stored messages of a channel, oldest first, without burning any
+// Expired and undecryptable messages are skipped, as get does
+func (s *Settings) History(ctx context.Context, id string, verbose bool) ([]*Message, error) {
+	key := s.DeriveKey(id)
+	history, err := s.FetchHistory(ctx, id, key, verbose)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := []*Message{}
+	for _, ev := range history.Messages {
+		if env, err := s.OpenMessage(ev, key); err == nil {
+			messages = append(messages, newMessage(id, ev, env))
+		}
+	}
+	return messages, nil
 }
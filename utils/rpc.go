@@ -0,0 +1,178 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// RPCHeader marks a message as a call request or its response
+type RPCHeader struct {
+	ID       string `json:"id"`                 // correlation ID chosen by the caller
+	ReplyTag string `json:"reply_tag"`          // tag the caller listens on for the response
+	Response bool   `json:"response,omitempty"` // set on the handler's reply
+	Error    string `json:"error,omitempty"`    // set when the handler failed
+}
+
+var RPCResponseTTL = time.Hour // how long relays keep responses for callers whose subscription was late
+
+// rpcOptions returns the send options for RPC traffic
+// Requests are ephemeral since servers are subscribed while they run; responses are stored
+// briefly so a caller whose subscription reached the relay after the response still gets it
+func rpcOptions(header *RPCHeader) SendOptions {
+	if header.Response {
+		return SendOptions{TTL: RPCResponseTTL, RPC: header}
+	}
+	return SendOptions{Ephemeral: true, RPC: header}
+}
+
+// Call publishes a request on a channel and prints the body of the matching response
+func Call(id string, payload string, timeout time.Duration, verbose bool) error {
+	startTime := time.Now()
+	key := DeriveKey(id)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	header := &RPCHeader{ID: randomHex(16), ReplyTag: randomHex(32)}
+
+	// Subscribe to the reply tag before publishing, so a fast handler can't be missed
+	// The tag is fresh, so stored events can only be our response even with clock skew
+	// On ephemeral channels the response is ephemeral too
+	filter := nostr.Filter{
+		Tags:  nostr.TagMap{"t": []string{header.ReplyTag}},
+		Kinds: append(ReadKinds(id), EphemeralKind),
+	}
+	tracker := NewStatusTracker(verbose)
+	events := SubscribeRelays(ctx, RelaysForReading(id), []nostr.Filter{filter}, tracker, verbose)
+
+	if verbose {
+		fmt.Printf("Calling %s (request %s)...\n", id, header.ID)
+	}
+
+	if _, err := sendMessage(ctx, id, payload, rpcOptions(header), verbose); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				if ctx.Err() != nil {
+					return fmt.Errorf("no response within %s", timeout)
+				}
+				return fmt.Errorf("lost connection to all relays")
+			}
+			env, err := OpenMessage(ev, key)
+			if err != nil || env.RPC == nil || !env.RPC.Response || env.RPC.ID != header.ID {
+				continue
+			}
+
+			fmt.Print(env.Body)
+
+			if verbose {
+				fmt.Printf("\nRound trip time: %dms\n", time.Since(startTime).Milliseconds())
+			}
+
+			if env.RPC.Error != "" {
+				return fmt.Errorf("handler failed: %s", env.RPC.Error)
+			}
+			return nil
+		case <-ctx.Done():
+			return fmt.Errorf("no response within %s", timeout)
+		}
+	}
+}
+
+// Serve runs a handler command for every request on a channel and publishes its stdout as the reply
+// The request body is passed on stdin; up to concurrency handlers run at once until interrupted,
+// and further requests wait for a free slot
+func Serve(id string, command string, concurrency int, verbose bool) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	return serve(ctx, id, command, concurrency, verbose)
+}
+
+// serve answers requests until the context is cancelled or every relay is lost
+func serve(ctx context.Context, id string, command string, concurrency int, verbose bool) error {
+	key := DeriveKey(id)
+	args, err := shellCommand(command)
+	if err != nil {
+		return err
+	}
+
+	tracker := NewStatusTracker(verbose)
+	events := SubscribeRelays(ctx, RelaysForReading(id), []nostr.Filter{LiveFilter(id, key)}, tracker, verbose)
+	// Cancellation kills running handlers through the context; they are waited for on the way out
+	var handlers sync.WaitGroup
+	defer handlers.Wait()
+
+	fmt.Printf("Serving %s with %s\n", id, command)
+
+	slots := make(chan struct{}, max(concurrency, 1))
+	for ev := range events {
+		env, err := OpenMessage(ev, key)
+		if err != nil || env.RPC == nil || env.RPC.Response || env.RPC.ReplyTag == "" {
+			continue
+		}
+
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil
+		}
+		handlers.Add(1)
+		go func() {
+			defer handlers.Done()
+			defer func() { <-slots }()
+			handleRequest(ctx, id, args, env, verbose)
+		}()
+	}
+
+	if ctx.Err() != nil {
+		return nil
+	}
+	return fmt.Errorf("lost connection to all relays")
+}
+
+// handleRequest runs the handler for one request and publishes the response
+func handleRequest(ctx context.Context, id string, args []string, request *Envelope, verbose bool) {
+	startTime := time.Now()
+
+	env := []string{"PULSE_CHANNEL=" + id, "PULSE_REQUEST_ID=" + request.RPC.ID}
+	stdout, err := runCommand(ctx, args, request.Body, env, 0)
+
+	response := &RPCHeader{ID: request.RPC.ID, ReplyTag: request.RPC.ReplyTag, Response: true}
+	if err != nil {
+		response.Error = err.Error()
+	}
+
+	// A handler that finished still gets its reply out when the server is stopped meanwhile
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	if _, err := CurrentSettings().SendMessage(ctx, id, string(stdout), rpcOptions(response), verbose); err != nil {
+		fmt.Fprintf(os.Stderr, "request %s: %v\n", request.RPC.ID, err)
+		return
+	}
+
+	if verbose {
+		status := "ok"
+		if response.Error != "" {
+			status = response.Error
+		}
+		fmt.Printf("\nrequest %s: %s in %dms\n", request.RPC.ID, status, time.Since(startTime).Milliseconds())
+	}
+}
+
+// randomHex returns n random bytes, hex-encoded
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
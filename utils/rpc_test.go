@@ -0,0 +1,126 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"pulse/internal/relaytest"
+)
+
+// startServer serves a channel with a handler command until the test ends
+func startServer(t *testing.T, relay *relaytest.Relay, id, command string, concurrency int) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- serve(ctx, id, command, concurrency, false)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("serve returned %v after cancellation", err)
+		}
+	})
+	relaytest.WaitFor(t, "the server to subscribe", func() bool {
+		return relay.Subscriptions() == 1
+	})
+}
+
+// callAll makes n calls on a channel at once and returns their errors
+func callAll(id string, n int, timeout time.Duration) []error {
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = Call(id, fmt.Sprintf("call %d", i), timeout, false)
+		}()
+	}
+	wg.Wait()
+	return errs
+}
+
+func TestCallRoundTrip(t *testing.T) {
+	relay := relaytest.New(t)
+	useRelays(t, relay.URL)
+	output := captureStdout(t)
+	startServer(t, relay, "rpc-test", "tr a-z A-Z", 1)
+
+	if err := Call("rpc-test", "ping", 10*time.Second, false); err != nil {
+		t.Fatal(err)
+	}
+	relaytest.WaitFor(t, "the response to be printed", func() bool {
+		return strings.Contains(output(), "PING")
+	})
+}
+
+func TestCallReportsHandlerFailure(t *testing.T) {
+	relay := relaytest.New(t)
+	useRelays(t, relay.URL)
+	output := captureStdout(t)
+	startServer(t, relay, "rpc-fail-test", "echo partial; exit 3", 1)
+
+	err := Call("rpc-fail-test", "ping", 10*time.Second, false)
+	if err == nil || !strings.Contains(err.Error(), "handler failed") {
+		t.Fatalf("calling a failing handler: err = %v, want the handler failure", err)
+	}
+	relaytest.WaitFor(t, "the handler's output to be printed", func() bool {
+		return strings.Contains(output(), "partial")
+	})
+}
+
+func TestCallTimesOut(t *testing.T) {
+	relay := relaytest.New(t)
+	useRelays(t, relay.URL)
+	captureStdout(t)
+
+	start := time.Now()
+	err := Call("rpc-nobody-test", "ping", 300*time.Millisecond, false)
+	if err == nil || !strings.Contains(err.Error(), "no response within") {
+		t.Fatalf("calling with no server: err = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("call gave up after %s, want about 300ms", elapsed)
+	}
+	relaytest.WaitFor(t, "the reply subscription to close", func() bool {
+		return relay.Subscriptions() == 0
+	})
+}
+
+func TestServeLimitsConcurrency(t *testing.T) {
+	relay := relaytest.New(t)
+	useRelays(t, relay.URL)
+	captureStdout(t)
+
+	// A handler fails if another one is running, since the lock directory already exists
+	lock := t.TempDir() + "/running"
+	startServer(t, relay, "rpc-serial-test", fmt.Sprintf("mkdir %q || exit 1; sleep 0.2; rmdir %q", lock, lock), 1)
+
+	for i, err := range callAll("rpc-serial-test", 3, 20*time.Second) {
+		if err != nil {
+			t.Errorf("call %d: %v", i, err)
+		}
+	}
+}
+
+func TestServeRunsHandlersConcurrently(t *testing.T) {
+	relay := relaytest.New(t)
+	useRelays(t, relay.URL)
+	captureStdout(t)
+
+	// Each handler waits until all three are running, so fewer slots would time out
+	dir := t.TempDir()
+	command := fmt.Sprintf(`touch %q/"$PULSE_REQUEST_ID"; while [ "$(ls %q | wc -l)" -lt 3 ]; do sleep 0.05; done`, dir, dir)
+	startServer(t, relay, "rpc-parallel-test", command, 3)
+
+	for i, err := range callAll("rpc-parallel-test", 3, 20*time.Second) {
+		if err != nil {
+			t.Errorf("call %d: %v", i, err)
+		}
+	}
+}
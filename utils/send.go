@@ -2,57 +2,36 @@ package utils
 
 import (
 	"context"
-	"encoding/hex"
+	"errors"
 	"fmt"
-	"time"
 
 	"github.com/nbd-wtf/go-nostr"
 )
 
-// SendMessage sends an encrypted message to the given ID
-func SendMessage(id string, message string, verbose bool) error {
-	startTime := time.Now()
-	key := DeriveKey(id)
-	hashedTag := hex.EncodeToString(key)
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+// SendMessage encrypts a message into a nostr event and publishes it to the channel's write relays
+// Errors other than ErrNoRelay mean the message could not be sealed and nothing was published
+func (s *Settings) SendMessage(ctx context.Context, id string, message string, opts SendOptions, verbose bool) (nostr.Event, error) {
+	key := s.DeriveKey(id)
 
-	if verbose {
-		fmt.Println("Sending message...")
-	}
-
-	// Encrypt the message
-	encrypted, err := Encrypt(message, key)
+	ev, err := s.NewMessageEvent(ctx, id, key, message, s.SigningKey(), opts, verbose)
 	if err != nil {
-		fmt.Print("Failure - encryption error")
-		return err
+		return ev, err
 	}
 
-	// Create nostr event
-	sk := nostr.GeneratePrivateKey()
-	pk, _ := nostr.GetPublicKey(sk)
-
-	ev := nostr.Event{
-		PubKey:    pk,
-		CreatedAt: nostr.Now(),
-		Kind:      nostr.KindTextNote,
-		Tags:      nostr.Tags{{"t", hashedTag}},
-		Content:   encrypted,
-	}
-	ev.Sign(sk)
-
-	// Publish to relays
-	err = PublishEvent(ctx, ev, verbose)
-	if err != nil {
-		fmt.Print("Failure - publish error")
-		return err
+	if verbose {
+		fmt.Printf("Event ID: %s\n", ev.ID)
 	}
 
-	fmt.Print("Success")
+	return ev, s.PublishEvent(ctx, opts.relays(s, id), ev, verbose)
+}
 
-	if verbose {
-		fmt.Printf("\nTotal operation time: %dms\n", time.Since(startTime).Milliseconds())
+// sendMessage is SendMessage for the CLI, reporting which step failed
+func sendMessage(ctx context.Context, id string, message string, opts SendOptions, verbose bool) (nostr.Event, error) {
+	ev, err := CurrentSettings().SendMessage(ctx, id, message, opts, verbose)
+	if errors.Is(err, ErrNoRelay) {
+		fmt.Print("Failure - publish error")
+	} else if err != nil {
+		fmt.Print("Failure - encryption error")
 	}
-
-	return nil
+	return ev, err
 }
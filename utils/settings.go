@@ -0,0 +1,128 @@
+package utils
+
+import "slices"
+
+// Settings is a complete Pulse configuration
+// The CLI keeps its configuration in the package variables, loaded from pulse.conf; programs
+// embedding Pulse build their own Settings so that clients don't share any state
+type Settings struct {
+	Relays       []string
+	ReadRelays   []string // relays to subscribe to, defaults to Relays
+	WriteRelays  []string // relays to publish to, defaults to Relays
+	Channels     map[string]*ChannelConfig
+	RelayConfigs map[string]*RelayConfig // by normalized URL
+
+	UserSecret   string
+	HistoryLimit int
+	IdentityKey  string // persistent signing key, empty means a fresh key per message
+	AuthKey      string // default NIP-42 identity key, used for relays without their own
+	Proxy        string // global proxy, consulted so .onion relays are never dialled directly
+
+	EventKind      int
+	EphemeralKind  int
+	LatestKind     int
+	ReadLegacy     bool // also read kind-1 notes written by older versions
+	Compression    string
+	Pow            int // NIP-13 proof-of-work for every published event
+	MaxPow         int // highest difficulty mined for a relay's advertised requirement
+	MaxMessageSize int // decompressed size limit
+
+	DataDir string                           // where NIP-11 documents are cached between runs, "" keeps them in memory
+	Log     func(format string, args ...any) // relay status as operations progress, nil discards it
+
+	transports  *relayTransports // proxy transports for relay traffic, nil shares the CLI's
+	connections *relayPool       // open relay connections, nil shares the CLI's
+	relayInfo   *relayInfoCache  // fetched NIP-11 documents, nil shares the CLI's
+	store       *messageStore    // the daemon's channel history, nil reads from the relays
+	outbox      *relayOutbox     // the daemon's publish retries, nil gives up on failed relays
+}
+
+// DataDir is where the CLI keeps caches and state files, set by the pulse command at startup
+var DataDir = ""
+
+// builtinSettings is the configuration before pulse.conf is applied; it only copies the
+// package variables, so importing the package touches no files
+var builtinSettings = packageSettings()
+
+// DefaultSettings returns a copy of the built-in configuration, without a data directory
+func DefaultSettings() *Settings {
+	s := builtinSettings.Clone()
+	s.Channels = make(map[string]*ChannelConfig)
+	s.RelayConfigs = make(map[string]*RelayConfig)
+	return s
+}
+
+// CurrentSettings returns the configuration held in the package variables
+// Its relay transports, connections and NIP-11 cache are shared by every call, so the CLI
+// reuses them, and inside the daemon it reads and publishes through the daemon's store and outbox
+func CurrentSettings() *Settings {
+	s := packageSettings()
+	s.DataDir = DataDir
+	s.transports = cliTransports
+	s.connections = cliRelays
+	s.relayInfo = cliRelayInfo
+	s.store = cliStore
+	s.outbox = cliOutbox
+	return s
+}
+
+func packageSettings() *Settings {
+	return &Settings{
+		Relays:       Relays,
+		ReadRelays:   ReadRelays,
+		WriteRelays:  WriteRelays,
+		Channels:     ChannelSettings,
+		RelayConfigs: RelaySettings,
+
+		UserSecret:   UserSecret,
+		HistoryLimit: HistoryLimit,
+		IdentityKey:  IdentityKey,
+		AuthKey:      AuthKey,
+		Proxy:        Proxy,
+
+		EventKind:      EventKind,
+		EphemeralKind:  EphemeralKind,
+		LatestKind:     LatestKind,
+		ReadLegacy:     ReadLegacyKind,
+		Compression:    Compression,
+		Pow:            PowDifficulty,
+		MaxPow:         MaxPowDifficulty,
+		MaxMessageSize: MaxMessageSize,
+	}
+}
+
+// Clone returns a copy that can be changed without affecting the original
+// The copy gets its own relay transports, connections and NIP-11 cache, and never uses the
+// daemon's store or outbox, so no state is ever shared with it
+func (s *Settings) Clone() *Settings {
+	c := *s
+	c.Relays = slices.Clone(s.Relays)
+	c.ReadRelays = slices.Clone(s.ReadRelays)
+	c.WriteRelays = slices.Clone(s.WriteRelays)
+	c.Channels = make(map[string]*ChannelConfig, len(s.Channels))
+	for id, config := range s.Channels {
+		copied := *config
+		copied.Relays = slices.Clone(config.Relays)
+		copied.ReadRelays = slices.Clone(config.ReadRelays)
+		copied.WriteRelays = slices.Clone(config.WriteRelays)
+		c.Channels[id] = &copied
+	}
+	c.RelayConfigs = make(map[string]*RelayConfig, len(s.RelayConfigs))
+	for url, config := range s.RelayConfigs {
+		copied := *config
+		c.RelayConfigs[url] = &copied
+	}
+	c.transports = &relayTransports{}
+	c.connections = &relayPool{}
+	c.relayInfo = &relayInfoCache{}
+	c.store = nil
+	c.outbox = nil
+	return &c
+}
+
+// logf reports progress to the configured log, if any
+func (s *Settings) logf(format string, args ...any) {
+	if s.Log != nil {
+		s.Log(format, args...)
+	}
+}
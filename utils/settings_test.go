@@ -0,0 +1,54 @@
+package utils
+
+import "testing"
+
+func TestCloneIsDeep(t *testing.T) {
+	s := DefaultSettings()
+	s.ReadRelays = []string{"wss://read.example.com"}
+	s.Channels["alerts"] = &ChannelConfig{Relays: []string{"wss://alerts.example.com"}, Kind: 4242}
+	s.RelayConfigs["wss://read.example.com"] = &RelayConfig{Pow: 8}
+
+	c := s.Clone()
+	c.Relays[0] = "wss://changed.example.com"
+	c.ReadRelays[0] = "wss://changed.example.com"
+	c.Channels["alerts"].Relays[0] = "wss://changed.example.com"
+	c.Channels["alerts"].Kind = 1
+	c.RelayConfigs["wss://read.example.com"].Pow = 20
+	c.Channels["new"] = &ChannelConfig{}
+
+	if s.Relays[0] == "wss://changed.example.com" || s.ReadRelays[0] == "wss://changed.example.com" {
+		t.Error("relay lists are shared with the clone")
+	}
+	if alerts := s.Channels["alerts"]; alerts.Relays[0] != "wss://alerts.example.com" || alerts.Kind != 4242 {
+		t.Errorf("channel config changed through the clone: %+v", alerts)
+	}
+	if pow := s.RelayConfigs["wss://read.example.com"].Pow; pow != 8 {
+		t.Errorf("relay config changed through the clone: pow = %d", pow)
+	}
+	if _, ok := s.Channels["new"]; ok {
+		t.Error("channel map is shared with the clone")
+	}
+	if c.transports == s.transports {
+		t.Error("clone shares relay transports with the original")
+	}
+}
+
+func TestCloneLeavesTheDaemonState(t *testing.T) {
+	cliStore, cliOutbox = &messageStore{}, &relayOutbox{}
+	t.Cleanup(func() { cliStore, cliOutbox = nil, nil })
+
+	s := CurrentSettings()
+	c := s.Clone()
+	if c.connections == s.connections || c.relayInfo == s.relayInfo {
+		t.Error("clone shares the CLI's relay connections or NIP-11 cache")
+	}
+	if c.store != nil || c.outbox != nil {
+		t.Error("clone reads or publishes through the daemon's store or outbox")
+	}
+}
+
+func TestDefaultSettingsHaveNoDataDir(t *testing.T) {
+	if dir := DefaultSettings().DataDir; dir != "" {
+		t.Errorf("DefaultSettings().DataDir = %q, want none", dir)
+	}
+}
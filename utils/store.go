@@ -0,0 +1,156 @@
+package utils
+
+import (
+	"context"
+	"encoding/hex"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// messageStore keeps the history of every channel the daemon has read in memory
+// Each channel is fetched once, then kept current by a live subscription
+type messageStore struct {
+	ctx context.Context
+
+	mu       sync.Mutex
+	channels map[string]*storedChannel // by channel ID
+	tags     map[string]string         // hashed tag -> channel ID
+}
+
+// storedChannel holds the raw events of one channel; deletions and acks are applied on read
+type storedChannel struct {
+	id    string
+	key   []byte
+	ready chan struct{} // closed once the initial history has been read
+
+	mu        sync.Mutex
+	seen      map[string]bool
+	messages  []*nostr.Event
+	deletions []*nostr.Event
+	acks      []*nostr.Event
+}
+
+var cliStore *messageStore // set while running as the daemon, nil for one-shot commands
+
+// enableMessageStore makes FetchHistory answer from memory until the context ends
+func enableMessageStore(ctx context.Context) {
+	cliStore = &messageStore{
+		ctx:      ctx,
+		channels: make(map[string]*storedChannel),
+		tags:     make(map[string]string),
+	}
+}
+
+// History returns a channel's history, loading it and subscribing to updates on first use
+func (s *messageStore) History(ctx context.Context, id string, key []byte) (*History, error) {
+	s.mu.Lock()
+	ch := s.channels[id]
+	if ch == nil {
+		ch = &storedChannel{id: id, key: key, ready: make(chan struct{}), seen: make(map[string]bool)}
+		s.channels[id] = ch
+		s.tags[hex.EncodeToString(key)] = id
+		go s.load(ch, key)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-ch.ready:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return ch.history(), nil
+}
+
+// load reads a channel's history and then follows it until every relay has dropped
+func (s *messageStore) load(ch *storedChannel, key []byte) {
+	// Followed from now on first, so events published while the history loads are kept too
+	now := nostr.Now()
+	messages := MessageFilter(ch.id, key)
+	messages.Since = &now
+	controls := ControlFilter(key)
+	controls.Since = &now
+	controls.Limit = 0
+	events := SubscribeRelays(s.ctx, RelaysForReading(ch.id), []nostr.Filter{messages, controls}, NewStatusTracker(false), false)
+
+	history, deletions, acks := CurrentSettings().fetchHistoryEvents(s.ctx, ch.id, key, false)
+	for _, list := range [][]*nostr.Event{history, deletions, acks} {
+		for _, ev := range list {
+			ch.add(ev)
+		}
+	}
+	close(ch.ready)
+
+	for ev := range events {
+		ch.add(ev)
+	}
+
+	// Forget the channel so the next read fetches it from scratch
+	s.mu.Lock()
+	if s.channels[ch.id] == ch {
+		delete(s.channels, ch.id)
+	}
+	s.mu.Unlock()
+}
+
+// Observe records an event the daemon published itself, so reads right after a send or a
+// burn don't depend on the relays echoing it back
+func (s *messageStore) Observe(ev nostr.Event) {
+	s.mu.Lock()
+	var ch *storedChannel
+	for tag := range ev.Tags.FindAll("t") {
+		if id, ok := s.tags[tag[1]]; ok {
+			ch = s.channels[id]
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	if ch == nil {
+		return
+	}
+	if ev.Kind != nostr.KindDeletion && ev.Kind != KindPulseAck && !slices.Contains(ReadKinds(ch.id), ev.Kind) {
+		return
+	}
+	<-ch.ready
+	ch.add(&ev)
+}
+
+// add files an event under messages, deletions or acks, ignoring duplicates
+func (ch *storedChannel) add(ev *nostr.Event) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	if ch.seen[ev.ID] {
+		return
+	}
+	ch.seen[ev.ID] = true
+
+	switch ev.Kind {
+	case nostr.KindDeletion:
+		ch.deletions = append(ch.deletions, ev)
+	case KindPulseAck:
+		ch.acks = append(ch.acks, ev)
+	default:
+		ch.messages = append(ch.messages, ev)
+	}
+}
+
+// history builds the channel's current history, keeping only the newest messages in memory
+func (ch *storedChannel) history() *History {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	// Relays are asked for HistoryLimit messages each, so a few times that covers every read
+	sort.Slice(ch.messages, func(i, j int) bool {
+		return ch.messages[i].CreatedAt < ch.messages[j].CreatedAt
+	})
+	if keep := 4 * max(HistoryLimit, 25); len(ch.messages) > keep {
+		ch.messages = slices.Clone(ch.messages[len(ch.messages)-keep:])
+	}
+
+	// newHistory filters in place, so it gets copies
+	return CurrentSettings().newHistory(ch.key, slices.Clone(ch.messages), slices.Clone(ch.deletions), slices.Clone(ch.acks))
+}
@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// SubscribeRelays streams events matching the filters from every relay, deduplicated across relays
+// Relays that close the subscription for NIP-42 auth are authenticated and resubscribed
+// The channel is closed once every relay has dropped out or the context is cancelled
+func SubscribeRelays(ctx context.Context, relays []string, filters []nostr.Filter, tracker *StatusTracker, verbose bool) <-chan *nostr.Event {
+	return CurrentSettings().subscribeRelays(ctx, relays, func(string) []nostr.Filter { return filters }, tracker, verbose)
+}
+
+// subscribeRelays is SubscribeRelays with filters chosen per relay
+func (s *Settings) subscribeRelays(ctx context.Context, relays []string, filtersFor func(url string) []nostr.Filter, tracker *StatusTracker, verbose bool) <-chan *nostr.Event {
+	out := make(chan *nostr.Event)
+	seen := make(map[string]bool)
+	var seenMu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, url := range relays {
+		tracker.AddRelay(url)
+		wg.Add(1)
+		go func(u string) {
+			defer wg.Done()
+
+			r, err := s.ConnectRelay(ctx, u)
+			if err != nil {
+				tracker.UpdateStatusWithReason(u, "error", err.Error())
+				return
+			}
+
+			sub, err := r.Subscribe(ctx, filtersFor(u))
+			if err != nil {
+				tracker.UpdateStatusWithReason(u, "error", err.Error())
+				return
+			}
+			tracker.UpdateStatusWithReason(u, "success", "subscribed")
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case reason := <-sub.ClosedReason:
+					sub, err = s.ResubscribeAfterClosed(ctx, r, u, sub, reason, tracker)
+					if err != nil {
+						tracker.UpdateStatusWithReason(u, "error", err.Error())
+						if verbose {
+							fmt.Printf("%s: %s\n", u, err)
+						}
+						return
+					}
+					if verbose {
+						fmt.Printf("%s: authenticated\n", u)
+					}
+				case ev, ok := <-sub.Events:
+					if !ok {
+						return
+					}
+					seenMu.Lock()
+					duplicate := seen[ev.ID]
+					seen[ev.ID] = true
+					seenMu.Unlock()
+					if duplicate {
+						continue
+					}
+
+					select {
+					case out <- ev:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}(url)
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out
+}